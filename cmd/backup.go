@@ -10,8 +10,9 @@ import (
 )
 
 type Backup struct {
-	configPath string
-	flagTags   []string
+	configPath    string
+	flagTags      []string
+	flagLimitRate string
 }
 
 func (cmd *Backup) Execute() error {
@@ -20,6 +21,10 @@ func (cmd *Backup) Execute() error {
 		return fmt.Errorf("get config: %v", err)
 	}
 
+	if err := applyLimitRate(cfg, cmd.flagLimitRate); err != nil {
+		return err
+	}
+
 	// Attach flagTags if exists.
 	cfg.Workspace.TagsGenerator = append(cfg.Workspace.TagsGenerator, cmd.flagTags...)
 
@@ -47,9 +52,15 @@ func init() {
 				log.Fatalln(err)
 			}
 
+			limitRate, err := cmd.Flags().GetString("limit-rate")
+			if err != nil {
+				log.Fatalln(err)
+			}
+
 			backup := Backup{
-				configPath: args[0],
-				flagTags:   tags,
+				configPath:    args[0],
+				flagTags:      tags,
+				flagLimitRate: limitRate,
 			}
 			if err := backup.Execute(); err != nil {
 				log.Fatalln(err)
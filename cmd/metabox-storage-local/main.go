@@ -0,0 +1,32 @@
+// Command metabox-storage-local is a reference helper for the `exec` storage
+// driver. It stores items in the directory given as its first argument:
+//
+//	backups:
+//	    - driver: exec
+//	      exec:
+//	          name: local
+//	          args: ["/srv/backups"]
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/nmcapule/metabox-go/config"
+	"github.com/nmcapule/metabox-go/storage"
+)
+
+func main() {
+	log.SetPrefix("metabox-storage-local: ")
+	if len(os.Args) != 2 {
+		log.Fatalln("usage: metabox-storage-local <path>")
+	}
+
+	store, err := storage.NewLocal(&config.LocalStorageConfig{Path: os.Args[1]})
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := storage.ServeHelper(store, os.Stdin, os.Stdout); err != nil {
+		log.Fatalln(err)
+	}
+}
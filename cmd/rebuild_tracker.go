@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/nmcapule/metabox-go/config"
+	"github.com/nmcapule/metabox-go/metabox"
+	"github.com/spf13/cobra"
+)
+
+type RebuildTracker struct {
+	configPath    string
+	flagReplace   bool
+	flagDryRun    bool
+	flagStores    []string
+	flagLimitRate string
+}
+
+func (cmd *RebuildTracker) Execute() error {
+	cfg, err := config.FromFile(cmd.configPath)
+	if err != nil {
+		return fmt.Errorf("get config: %v", err)
+	}
+	if err := applyLimitRate(cfg, cmd.flagLimitRate); err != nil {
+		return err
+	}
+
+	box, err := metabox.New(cfg)
+	if err != nil {
+		return fmt.Errorf("metabox from config: %v", err)
+	}
+
+	return box.RebuildTracker(metabox.RebuildOptions{
+		Replace: cmd.flagReplace,
+		DryRun:  cmd.flagDryRun,
+		Stores:  cmd.flagStores,
+	})
+}
+
+func init() {
+	cmdRebuildTracker := &cobra.Command{
+		Use:   "rebuild-tracker",
+		Short: "Recreates backup records from the metadata in stores",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			replace, err := cmd.Flags().GetBool("replace")
+			if err != nil {
+				log.Fatalln(err)
+			}
+			dryRun, err := cmd.Flags().GetBool("dry-run")
+			if err != nil {
+				log.Fatalln(err)
+			}
+			stores, err := cmd.Flags().GetStringArray("store")
+			if err != nil {
+				log.Fatalln(err)
+			}
+			limitRate, err := cmd.Flags().GetString("limit-rate")
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			r := RebuildTracker{
+				configPath:    args[0],
+				flagReplace:   replace,
+				flagDryRun:    dryRun,
+				flagStores:    stores,
+				flagLimitRate: limitRate,
+			}
+			if err := r.Execute(); err != nil {
+				log.Fatalln(err)
+			}
+		},
+	}
+	cmdRebuildTracker.Flags().Bool("replace", false, "Discard current records instead of merging")
+	cmdRebuildTracker.Flags().Bool("dry-run", false, "Only report what would be changed")
+	cmdRebuildTracker.Flags().StringArrayP("store", "s", nil, "Names of stores to read from. Defaults to all")
+
+	root.AddCommand(cmdRebuildTracker)
+}
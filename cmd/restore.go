@@ -4,18 +4,32 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/nmcapule/metabox-go/config"
 	"github.com/nmcapule/metabox-go/metabox"
 	"github.com/nmcapule/metabox-go/tracker"
 	"github.com/spf13/cobra"
 )
 
 type Restore struct {
-	configPath string
-	flagTags   []string
+	configPath      string
+	flagTags        []string
+	flagLimitRate   string
+	flagNoSameOwner bool
 }
 
 func (cmd *Restore) Execute() error {
-	box, err := metabox.FromConfigFile(cmd.configPath)
+	cfg, err := config.FromFile(cmd.configPath)
+	if err != nil {
+		return fmt.Errorf("get config: %v", err)
+	}
+	if err := applyLimitRate(cfg, cmd.flagLimitRate); err != nil {
+		return err
+	}
+	if cmd.flagNoSameOwner {
+		cfg.Workspace.Options.NoSameOwner = true
+	}
+
+	box, err := metabox.New(cfg)
 	if err != nil {
 		return fmt.Errorf("metabox from config: %v", err)
 	}
@@ -44,9 +58,21 @@ func init() {
 				log.Fatalln(err)
 			}
 
+			limitRate, err := cmd.Flags().GetString("limit-rate")
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			noSameOwner, err := cmd.Flags().GetBool("no-same-owner")
+			if err != nil {
+				log.Fatalln(err)
+			}
+
 			r := Restore{
-				configPath: args[0],
-				flagTags:   tags,
+				configPath:      args[0],
+				flagTags:        tags,
+				flagLimitRate:   limitRate,
+				flagNoSameOwner: noSameOwner,
 			}
 			if err := r.Execute(); err != nil {
 				log.Fatalln(err)
@@ -54,6 +80,7 @@ func init() {
 		},
 	}
 	cmdRestore.Flags().StringArrayP("tags", "t", nil, "Tag matchers")
+	cmdRestore.Flags().Bool("no-same-owner", false, "Restore files as the current user instead of their recorded owner")
 
 	root.AddCommand(cmdRestore)
 }
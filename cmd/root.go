@@ -1,17 +1,36 @@
 package cmd
 
 import (
+	"fmt"
 	"log"
 
+	"github.com/nmcapule/metabox-go/config"
 	"github.com/spf13/cobra"
 )
 
 var root = &cobra.Command{
-	Use:   "metabox [restore|backup]",
+	Use:   "metabox [restore|backup|sync|rebuild-tracker]",
 	Short: "VCS-friendly backup/restore tool",
 	Args:  cobra.MinimumNArgs(1),
 }
 
+func init() {
+	root.PersistentFlags().String("limit-rate", "", "Maximum transfer rate of all stores combined, e.g. 512K or 2M")
+}
+
+// applyLimitRate overrides the global rate limit of cfg if limitRate is set.
+func applyLimitRate(cfg *config.Config, limitRate string) error {
+	if limitRate == "" {
+		return nil
+	}
+	rate, err := config.ParseRate(limitRate)
+	if err != nil {
+		return fmt.Errorf("--limit-rate: %v", err)
+	}
+	cfg.Workspace.Options.RateLimit = rate
+	return nil
+}
+
 func Execute() {
 	if err := root.Execute(); err != nil {
 		log.Fatalln(err)
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/nmcapule/metabox-go/config"
+	"github.com/nmcapule/metabox-go/metabox"
+	"github.com/spf13/cobra"
+)
+
+type Sync struct {
+	configPath    string
+	flagDryRun    bool
+	flagStores    []string
+	flagLimitRate string
+}
+
+func (cmd *Sync) Execute() error {
+	cfg, err := config.FromFile(cmd.configPath)
+	if err != nil {
+		return fmt.Errorf("get config: %v", err)
+	}
+	if err := applyLimitRate(cfg, cmd.flagLimitRate); err != nil {
+		return err
+	}
+
+	box, err := metabox.New(cfg)
+	if err != nil {
+		return fmt.Errorf("metabox from config: %v", err)
+	}
+
+	return box.Sync(metabox.SyncOptions{
+		DryRun: cmd.flagDryRun,
+		Stores: cmd.flagStores,
+	})
+}
+
+func init() {
+	cmdSync := &cobra.Command{
+		Use:   "sync",
+		Short: "Copies tracked backups to stores that are missing them",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			dryRun, err := cmd.Flags().GetBool("dry-run")
+			if err != nil {
+				log.Fatalln(err)
+			}
+			stores, err := cmd.Flags().GetStringArray("store")
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			limitRate, err := cmd.Flags().GetString("limit-rate")
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			s := Sync{
+				configPath:    args[0],
+				flagDryRun:    dryRun,
+				flagStores:    stores,
+				flagLimitRate: limitRate,
+			}
+			if err := s.Execute(); err != nil {
+				log.Fatalln(err)
+			}
+		},
+	}
+	cmdSync.Flags().Bool("dry-run", false, "Only report what would be copied")
+	cmdSync.Flags().StringArrayP("store", "s", nil, "Names of stores to sync to. Defaults to all")
+
+	root.AddCommand(cmdSync)
+}
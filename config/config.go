@@ -5,6 +5,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/creasty/defaults"
 	"github.com/go-yaml/yaml"
@@ -14,6 +17,7 @@ type WorkspaceConfig struct {
 	RootPath       string   `yaml:"root_path"`
 	CachePath      string   `yaml:"cache_path" default:"./cache"`
 	VersionsPath   string   `yaml:"versions_path" default:"./backups.txt"`
+	Project        string   `yaml:"project"`
 	UserIdentifier string   `yaml:"user_identifier" default:"anonymous"`
 	TagsGenerator  []string `yaml:"tags_generator"`
 	Hooks          struct {
@@ -23,9 +27,22 @@ type WorkspaceConfig struct {
 		PostRestore []string `yaml:"post_restore"`
 	} `yaml:"hooks"`
 	Options struct {
-		Compress string `yaml:"compress" default:"tgz"`
-		Hash     string `yaml:"hash" default:"md5"`
+		Archive         string      `yaml:"archive" default:"tar"`
+		Compress        string      `yaml:"compress" default:"tgz"`
+		CompressLevel   int         `yaml:"compress_level"`
+		CompressThreads int         `yaml:"compress_threads"`
+		Hash            string      `yaml:"hash" default:"md5"`
+		UploadPolicy    string      `yaml:"upload_policy" default:"all"`
+		UploadQuorum    int         `yaml:"upload_quorum"`
+		RateLimit       Rate        `yaml:"rate_limit"`
+		NoSameOwner     bool        `yaml:"no_same_owner"`
+		UIDMap          map[int]int `yaml:"uid_map"`
+		GIDMap          map[int]int `yaml:"gid_map"`
 	} `yaml:"options"`
+	Encryption struct {
+		Recipients   []string `yaml:"recipients"`
+		IdentityFile string   `yaml:"identity_file"`
+	} `yaml:"encryption"`
 }
 
 type TargetDriver string
@@ -40,18 +57,150 @@ type TargetConfig struct {
 	Excludes   []string `yaml:"excludes"`
 }
 
+// Upload policies decide how many stores must succeed for a backup to succeed.
+const (
+	UploadPolicyAll    = "all"
+	UploadPolicyAny    = "any"
+	UploadPolicyQuorum = "quorum"
+)
+
 type BackupDriver string
 
 const (
-	BackupDriverS3    = "s3"
-	BackupDriverLocal = "local"
+	BackupDriverS3     = "s3"
+	BackupDriverLocal  = "local"
+	BackupDriverRemote = "remote"
+	BackupDriverWebDAV = "webdav"
+	BackupDriverHTTP   = "http"
+	BackupDriverExec   = "exec"
+	BackupDriverMemory = "memory"
 )
 
 type BackupConfig struct {
-	Driver string              `yaml:"driver"`
-	S3     S3StorageConfig     `yaml:"s3"`
-	Local  LocalStorageConfig  `yaml:"local"`
-	Remote RemoteStorageConfig `yaml:"remote"`
+	Name     string              `yaml:"name"`
+	Driver   string              `yaml:"driver"`
+	Priority int                 `yaml:"priority"`
+	S3       S3StorageConfig     `yaml:"s3"`
+	Local    LocalStorageConfig  `yaml:"local"`
+	Remote   RemoteStorageConfig `yaml:"remote"`
+	WebDAV   WebDAVStorageConfig `yaml:"webdav"`
+	HTTP     HTTPStorageConfig   `yaml:"http"`
+	Exec     ExecStorageConfig   `yaml:"exec"`
+	Retry    RetryConfig         `yaml:"retry"`
+	// RateLimit caps the transfer rate of this store in bytes per second.
+	RateLimit Rate `yaml:"rate_limit"`
+	// KeyTemplate is a text/template for the keys of archives in this store.
+	KeyTemplate string `yaml:"key_template"`
+
+	// raw keeps all keys of the backup entry so that drivers registered
+	// outside of this package can decode their own section.
+	raw map[string]interface{}
+}
+
+// UnmarshalYAML decodes the backup entry and retains its raw contents.
+func (c *BackupConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain BackupConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return unmarshal(&c.raw)
+}
+
+// Section decodes the config section named after the driver into out. For
+// example, a backup entry with `driver: foo` decodes its `foo:` section.
+// Missing sections leave out with only its defaults set.
+func (c *BackupConfig) Section(out interface{}) error {
+	if section, ok := c.raw[c.Driver]; ok {
+		b, err := yaml.Marshal(section)
+		if err != nil {
+			return fmt.Errorf("encode %q section: %v", c.Driver, err)
+		}
+		if err := yaml.Unmarshal(b, out); err != nil {
+			return fmt.Errorf("decode %q section: %v", c.Driver, err)
+		}
+	}
+	if err := defaults.Set(out); err != nil {
+		return fmt.Errorf("set defaults of %q section: %v", c.Driver, err)
+	}
+	return nil
+}
+
+// RetryConfig configures retries of failed storage operations. Attempts of 0
+// or 1 disable retrying.
+type RetryConfig struct {
+	Attempts   int           `yaml:"attempts"`
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+	Timeout    time.Duration `yaml:"timeout"`
+}
+
+// Size is a number of bytes.
+type Size int64
+
+// ParseSize parses a size such as "512K", "1.5M" or "2G", where the suffixes
+// are powers of 1024. A plain number is in bytes.
+func ParseSize(size string) (Size, error) {
+	s := strings.TrimSpace(size)
+	unit := 1.0
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'k', 'K':
+			unit = 1 << 10
+		case 'm', 'M':
+			unit = 1 << 20
+		case 'g', 'G':
+			unit = 1 << 30
+		}
+		if unit != 1 {
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return Size(v * unit), nil
+}
+
+// UnmarshalYAML decodes a size with ParseSize.
+func (s *Size) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err != nil {
+		return err
+	}
+	size, err := ParseSize(str)
+	if err != nil {
+		return err
+	}
+	*s = size
+	return nil
+}
+
+// Rate is a transfer rate in bytes per second. Zero means unlimited.
+type Rate int64
+
+// ParseRate parses a rate with the same suffixes as ParseSize, like curl's
+// --limit-rate.
+func ParseRate(rate string) (Rate, error) {
+	size, err := ParseSize(rate)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", rate)
+	}
+	return Rate(size), nil
+}
+
+// UnmarshalYAML decodes a rate with ParseRate.
+func (r *Rate) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	rate, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+	*r = rate
+	return nil
 }
 
 type LocalStorageConfig struct {
@@ -63,6 +212,27 @@ type RemoteStorageConfig struct {
 	Host            string `yaml:"host"`
 	User            string `yaml:"user"`
 	CredentialsPath string `yaml:"ssh_credential_file"`
+	KnownHostsPath  string `yaml:"known_hosts_file"`
+}
+
+type WebDAVStorageConfig struct {
+	URL        string `yaml:"url"`
+	PrefixPath string `yaml:"prefix_path"`
+	Username   string `yaml:"username"`
+	Password   string `yaml:"password"`
+	Token      string `yaml:"token"`
+}
+
+type HTTPStorageConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	CAFile  string            `yaml:"ca_file"`
+}
+
+type ExecStorageConfig struct {
+	Name string            `yaml:"name"`
+	Args []string          `yaml:"args"`
+	Env  map[string]string `yaml:"env"`
 }
 
 type S3StorageConfig struct {
@@ -72,6 +242,35 @@ type S3StorageConfig struct {
 	Region          string `yaml:"region"`
 	Bucket          string `yaml:"bucket"`
 	Endpoint        string `yaml:"endpoint"`
+	// Profile selects a profile of the shared AWS config and credentials
+	// files. Used when the access keys are empty.
+	Profile        string `yaml:"profile"`
+	ForcePathStyle bool   `yaml:"force_path_style"`
+	DisableSSL     bool   `yaml:"disable_ssl"`
+	CAFile         string `yaml:"ca_file"`
+
+	// Object options applied to every uploaded archive.
+	StorageClass         string             `yaml:"storage_class"`
+	ServerSideEncryption string             `yaml:"server_side_encryption"`
+	KMSKeyID             string             `yaml:"kms_key_id"`
+	ACL                  string             `yaml:"acl"`
+	ObjectLock           S3ObjectLockConfig `yaml:"object_lock"`
+	// TagObjects mirrors the metabox tags of a backup as S3 object tags.
+	TagObjects bool `yaml:"tag_objects"`
+
+	// Multipart upload options. If StateDir is set, multipart uploads save
+	// their progress there and resume it when the upload is retried.
+	PartSize    Size   `yaml:"part_size"`
+	Concurrency int    `yaml:"concurrency"`
+	StateDir    string `yaml:"state_dir"`
+}
+
+// S3ObjectLockConfig configures Object Lock retention of uploaded archives.
+// The bucket must have Object Lock enabled.
+type S3ObjectLockConfig struct {
+	Mode      string        `yaml:"mode"`
+	RetainFor time.Duration `yaml:"retain_for"`
+	LegalHold bool          `yaml:"legal_hold"`
 }
 
 type Config struct {
@@ -3,13 +3,20 @@ module github.com/nmcapule/metabox-go
 go 1.14
 
 require (
+	filippo.io/age v1.0.0
 	github.com/aws/aws-sdk-go v1.34.27
 	github.com/bmatcuk/doublestar v1.3.1
 	github.com/creasty/defaults v1.5.0
 	github.com/go-yaml/yaml v2.1.0+incompatible
 	github.com/jszwec/csvutil v1.4.0
+	github.com/klauspost/compress v1.11.13
+	github.com/klauspost/pgzip v1.2.5
 	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
+	github.com/pkg/sftp v1.13.5
 	github.com/spf13/cobra v1.0.0
+	github.com/ulikunitz/xz v0.5.11
+	golang.org/x/crypto v0.0.0-20220314234659-1baeb1ce4c0b
+	golang.org/x/net v0.0.0-20211112202133-69e39bad7dc2
 	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
 	gopkg.in/yaml.v2 v2.3.0 // indirect
 )
@@ -0,0 +1,23 @@
+// Package testenv helps tests change the environment of the process.
+package testenv
+
+import (
+	"os"
+	"testing"
+)
+
+// Setenv sets the environment variable key to value until t finishes, when
+// its previous value is restored.
+func Setenv(t testing.TB, key, value string) {
+	prev, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatalf("setenv %s: %v", key, err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, prev)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
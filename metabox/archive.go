@@ -0,0 +1,232 @@
+package metabox
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nmcapule/metabox-go/tracker"
+)
+
+// Names of archive formats, as used in options.archive and recorded in
+// tracker entries.
+const (
+	archiveTar = "tar"
+	archiveZip = "zip"
+)
+
+// archiveWriter adds entries to an archive. Entries are described by tar
+// headers regardless of the format.
+type archiveWriter interface {
+	// Create adds an entry and returns a writer for its contents.
+	Create(hdr *tar.Header) (io.Writer, error)
+	// Close finishes the archive. It does not close the underlying file.
+	Close() error
+}
+
+// archiveReader iterates over the entries of an archive.
+type archiveReader interface {
+	// Next returns the next entry and a reader for its contents, or io.EOF
+	// at the end of the archive.
+	Next() (*tar.Header, io.Reader, error)
+	Close() error
+}
+
+// archiveFormat returns the archive format of item. Entries without a recorded
+// format are tar archives.
+func archiveFormat(item *tracker.Item) string {
+	if format := item.Meta[tracker.MetaArchive]; format != "" {
+		return format
+	}
+	return archiveTar
+}
+
+// archiveName returns the configured archive format.
+func (m *Metabox) archiveName() (string, error) {
+	switch name := m.Config.Workspace.Options.Archive; name {
+	case "", archiveTar:
+		return archiveTar, nil
+	case archiveZip:
+		return archiveZip, nil
+	default:
+		return "", fmt.Errorf("unknown archive format: %q", name)
+	}
+}
+
+// newArchiveWriter creates a writer of item's archive format on file.
+func (m *Metabox) newArchiveWriter(item *tracker.Item, file io.Writer) (archiveWriter, error) {
+	switch format := archiveFormat(item); format {
+	case archiveZip:
+		return &zipWriter{zw: zip.NewWriter(file)}, nil
+	case archiveTar:
+		c, err := m.codecOf(item)
+		if err != nil {
+			return nil, err
+		}
+		cw, err := c.NewWriter(file)
+		if err != nil {
+			return nil, fmt.Errorf("creating compressed writer: %v", err)
+		}
+		return &tarWriter{tw: tar.NewWriter(cw), cw: cw}, nil
+	default:
+		return nil, fmt.Errorf("unknown archive format: %q", format)
+	}
+}
+
+// newArchiveReader creates a reader of item's archive format on file.
+func (m *Metabox) newArchiveReader(item *tracker.Item, file *os.File) (archiveReader, error) {
+	switch format := archiveFormat(item); format {
+	case archiveZip:
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zip.NewReader(file, info.Size())
+		if err != nil {
+			return nil, fmt.Errorf("reading zip: %v", err)
+		}
+		return &zipReader{files: zr.File}, nil
+	case archiveTar:
+		c, err := m.codecOf(item)
+		if err != nil {
+			return nil, err
+		}
+		cr, err := c.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("creating compressed reader: %v", err)
+		}
+		return &tarReader{tr: tar.NewReader(cr), cr: cr}, nil
+	default:
+		return nil, fmt.Errorf("unknown archive format: %q", format)
+	}
+}
+
+type tarWriter struct {
+	tw *tar.Writer
+	cw io.WriteCloser
+}
+
+func (w *tarWriter) Create(hdr *tar.Header) (io.Writer, error) {
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+	return w.tw, nil
+}
+
+func (w *tarWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		w.cw.Close()
+		return err
+	}
+	return w.cw.Close()
+}
+
+type tarReader struct {
+	tr *tar.Reader
+	cr io.ReadCloser
+}
+
+func (r *tarReader) Next() (*tar.Header, io.Reader, error) {
+	hdr, err := r.tr.Next()
+	if err != nil {
+		return nil, nil, err
+	}
+	return hdr, r.tr, nil
+}
+
+func (r *tarReader) Close() error {
+	return r.cr.Close()
+}
+
+// zipWriter writes entries deflated, switching to ZIP64 for large files.
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (w *zipWriter) Create(hdr *tar.Header) (io.Writer, error) {
+	fh, err := zip.FileInfoHeader(hdr.FileInfo())
+	if err != nil {
+		return nil, err
+	}
+	fh.Name = hdr.Name
+	if hdr.ModTime.IsZero() {
+		// Leave the date unset rather than encode the zero time.
+		fh.Modified = time.Time{}
+		fh.ModifiedDate, fh.ModifiedTime = 0, 0
+	}
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		fh.Name = strings.TrimSuffix(fh.Name, "/") + "/"
+		fh.Method = zip.Store
+	case tar.TypeSymlink:
+		// Like Info-ZIP, store the target of symbolic links as their contents.
+		fh.Method = zip.Store
+		zw, err := w.zw.CreateHeader(fh)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(zw, hdr.Linkname); err != nil {
+			return nil, err
+		}
+		return zw, nil
+	default:
+		fh.Method = zip.Deflate
+	}
+	return w.zw.CreateHeader(fh)
+}
+
+func (w *zipWriter) Close() error {
+	return w.zw.Close()
+}
+
+type zipReader struct {
+	files []*zip.File
+	rc    io.ReadCloser
+}
+
+func (r *zipReader) Next() (*tar.Header, io.Reader, error) {
+	r.Close()
+	if len(r.files) == 0 {
+		return nil, nil, io.EOF
+	}
+	f := r.files[0]
+	r.files = r.files[1:]
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", f.Name, err)
+	}
+	r.rc = rc
+
+	var link string
+	if f.Mode()&os.ModeSymlink != 0 {
+		b, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", f.Name, err)
+		}
+		link = string(b)
+	}
+
+	hdr, err := tar.FileInfoHeader(f.FileInfo(), link)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", f.Name, err)
+	}
+	hdr.Name = f.Name
+	// Zip archives do not record ownership.
+	hdr.Uid, hdr.Gid = -1, -1
+	return hdr, rc, nil
+}
+
+func (r *zipReader) Close() error {
+	if r.rc == nil {
+		return nil
+	}
+	err := r.rc.Close()
+	r.rc = nil
+	return err
+}
@@ -2,46 +2,162 @@ package metabox
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/nmcapule/metabox-go/config"
+	"github.com/nmcapule/metabox-go/storage"
+	"github.com/nmcapule/metabox-go/tracker"
 )
 
-func (m *Metabox) uploadToBackups(sum string) error {
-	filename := m.compressedFilename(sum)
-	filepath := filepath.Join(m.derivedCachePath(), filename)
+// uploadToBackups uploads the archive to all stores concurrently. Whether the
+// upload as a whole succeeds is decided by the configured upload policy.
+func (m *Metabox) uploadToBackups(item *tracker.Item) error {
+	required, err := m.requiredUploads()
+	if err != nil {
+		return err
+	}
 
-	for _, store := range m.Stores {
-		file, err := os.Open(filepath)
-		if err != nil {
-			return fmt.Errorf("open %q: %v", filepath, err)
-		}
-		defer file.Close()
+	results := make([]error, len(m.Stores))
+	var wg sync.WaitGroup
+	for i := range m.Stores {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = m.uploadToStore(i, item)
+		}(i)
+	}
+	wg.Wait()
 
-		if err := store.Upload(filename, file); err != nil {
-			return fmt.Errorf("upload: %v", err)
+	var errs storeErrors
+	for i, err := range results {
+		if err != nil {
+			log.Printf("upload to %s failed: %v", m.storeName(i), err)
+			errs = append(errs, storeError{store: m.storeName(i), err: err})
 		}
-		log.Printf("upload: %s", filepath)
 	}
-
+	if succeeded := len(m.Stores) - len(errs); succeeded < required {
+		return fmt.Errorf("upload: %d of %d required stores succeeded: %v", succeeded, required, errs)
+	}
 	return nil
 }
 
-func (m *Metabox) downloadFromBackups(sum string) error {
-	filename := m.compressedFilename(sum)
-	filepath := filepath.Join(m.derivedCachePath(), filename)
-	file, err := os.Create(filepath)
+// uploadToStore uploads the cached archive to the i-th store.
+func (m *Metabox) uploadToStore(i int, item *tracker.Item) error {
+	filepath := filepath.Join(m.derivedCachePath(), m.storedFilename(item))
+	file, err := os.Open(filepath)
 	if err != nil {
 		return fmt.Errorf("open %q: %v", filepath, err)
 	}
 	defer file.Close()
 
-	for _, store := range m.Stores {
-		if err := store.Download(filename, file); err != nil {
-			return fmt.Errorf("download: %v", err)
+	key := m.storeKey(i, item)
+	if err := m.Stores[i].Upload(key, file); err != nil {
+		return err
+	}
+	if tagger, ok := storage.AsTagger(m.Stores[i]); ok {
+		if err := tagger.Tag(key, item.Tags); err != nil {
+			return err
 		}
-		log.Printf("download: %s", filepath)
-		return nil
 	}
-	return errNoAvailableStores
+
+	// Describe the archive next to it, so that the tracker can be rebuilt.
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat %q: %v", filepath, err)
+	}
+	if err := m.uploadMetadata(i, item, key, info.Size()); err != nil {
+		return err
+	}
+	log.Printf("upload: %s (to %s)", filepath, m.storeName(i))
+	return nil
+}
+
+// requiredUploads returns the number of stores that must receive the archive
+// for an upload to succeed, according to the upload policy. An empty policy
+// means all.
+func (m *Metabox) requiredUploads() (int, error) {
+	options := m.Config.Workspace.Options
+	switch options.UploadPolicy {
+	case "", config.UploadPolicyAll:
+		return len(m.Stores), nil
+	case config.UploadPolicyAny:
+		if len(m.Stores) == 0 {
+			return 0, nil
+		}
+		return 1, nil
+	case config.UploadPolicyQuorum:
+		if options.UploadQuorum < 1 || options.UploadQuorum > len(m.Stores) {
+			return 0, fmt.Errorf("upload quorum %d out of range [1, %d]", options.UploadQuorum, len(m.Stores))
+		}
+		return options.UploadQuorum, nil
+	default:
+		return 0, fmt.Errorf("unknown upload policy: %q", options.UploadPolicy)
+	}
+}
+
+// downloadFromBackups fetches the archive from the first store that serves it,
+// trying stores in order of priority.
+func (m *Metabox) downloadFromBackups(item *tracker.Item) error {
+	return m.downloadFromStores(item, m.storesByPriority())
+}
+
+// downloadFromStores fetches the archive into the cache from the first of the
+// stores with the given indices that serves it.
+func (m *Metabox) downloadFromStores(item *tracker.Item, indices []int) error {
+	if len(indices) == 0 {
+		return errNoAvailableStores
+	}
+
+	// Download into a temporary file, so that an interrupted download never
+	// leaves a partial archive in the cache.
+	filepath := filepath.Join(m.derivedCachePath(), m.storedFilename(item))
+	return storage.WriteFileAtomic(filepath, func(file *os.File) error {
+		var errs storeErrors
+		for _, i := range indices {
+			name := m.storeName(i)
+
+			// Discard anything written by a previously failed attempt.
+			if err := file.Truncate(0); err != nil {
+				return fmt.Errorf("truncate %q: %v", file.Name(), err)
+			}
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("seek %q: %v", file.Name(), err)
+			}
+
+			if err := m.Stores[i].Download(m.storeKey(i, item), file); err != nil {
+				log.Printf("download from %s failed: %v", name, err)
+				errs = append(errs, storeError{store: name, err: err})
+				continue
+			}
+			log.Printf("download: %s (from %s)", filepath, name)
+			return nil
+		}
+		return fmt.Errorf("download: %v", errs)
+	})
+}
+
+// storesByPriority returns the indices of m.Stores sorted by ascending
+// priority. Stores with equal priority keep their configured order.
+func (m *Metabox) storesByPriority() []int {
+	indices := make([]int, len(m.Stores))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(a, b int) bool {
+		return m.Config.Backups[indices[a]].Priority < m.Config.Backups[indices[b]].Priority
+	})
+	return indices
+}
+
+// storeName returns a human-readable name of the i-th store.
+func (m *Metabox) storeName(i int) string {
+	if name := m.Config.Backups[i].Name; name != "" {
+		return name
+	}
+	return fmt.Sprintf("%s#%d", m.Config.Backups[i].Driver, i)
 }
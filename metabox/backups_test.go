@@ -0,0 +1,237 @@
+package metabox
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nmcapule/metabox-go/config"
+	"github.com/nmcapule/metabox-go/storage"
+	"github.com/nmcapule/metabox-go/tracker"
+)
+
+func TestRequiredUploads(t *testing.T) {
+	tests := []struct {
+		policy  string
+		quorum  int
+		want    int
+		wantErr bool
+	}{
+		{"", 0, 3, false},
+		{config.UploadPolicyAll, 0, 3, false},
+		{config.UploadPolicyAny, 0, 1, false},
+		{config.UploadPolicyQuorum, 2, 2, false},
+		{config.UploadPolicyQuorum, 4, 0, true},
+		{"most", 0, 0, true},
+	}
+	for _, tt := range tests {
+		m := &Metabox{
+			Config: &config.Config{},
+			Stores: []storage.Storage{storage.NewMemory(), storage.NewMemory(), storage.NewMemory()},
+		}
+		m.Config.Workspace.Options.UploadPolicy = tt.policy
+		m.Config.Workspace.Options.UploadQuorum = tt.quorum
+
+		got, err := m.requiredUploads()
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("requiredUploads() with policy %q = %d, %v; want %d, error %v", tt.policy, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestUploadPolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		quorum int
+		// failing names the stores of a, b and c that fail.
+		failing []string
+		wantErr bool
+	}{
+		{config.UploadPolicyAll, 0, nil, false},
+		{config.UploadPolicyAll, 0, []string{"b"}, true},
+		{config.UploadPolicyAny, 0, []string{"b"}, false},
+		{config.UploadPolicyAny, 0, []string{"a", "c"}, false},
+		{config.UploadPolicyAny, 0, []string{"a", "b", "c"}, true},
+		{config.UploadPolicyQuorum, 2, []string{"c"}, false},
+		{config.UploadPolicyQuorum, 2, []string{"a", "c"}, true},
+	}
+	for _, tt := range tests {
+		m := newTestMetabox(t)
+		writeTree(t, m.derivedTargetPath(), map[string]string{"a.txt": "a"})
+		item := backupTo(t, m, "policy", archiveTar)
+		m.Config.Workspace.Options.UploadPolicy = tt.policy
+		m.Config.Workspace.Options.UploadQuorum = tt.quorum
+
+		failing := make(map[string]bool)
+		for _, name := range tt.failing {
+			failing[name] = true
+		}
+		m.Config.Backups = nil
+		m.Stores = nil
+		for _, name := range []string{"a", "b", "c"} {
+			store := &testStore{Storage: storage.NewMemory(), name: name}
+			if failing[name] {
+				store.fail = errors.New("connection reset")
+			}
+			m.Config.Backups = append(m.Config.Backups, config.BackupConfig{Name: name})
+			m.Stores = append(m.Stores, store)
+		}
+
+		err := m.uploadToBackups(item)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("uploadToBackups() with policy %q and %q failing error = %v; want error %v", tt.policy, tt.failing, err, tt.wantErr)
+			continue
+		}
+		for i, store := range m.Stores {
+			name := m.storeName(i)
+			exists, _ := store.Exists(m.storeKey(i, item))
+			if exists == failing[name] {
+				t.Errorf("policy %q with %q failing: archive in %s = %v; want %v", tt.policy, tt.failing, name, exists, !failing[name])
+			}
+			// The error names the failed stores only.
+			if err != nil && strings.Contains(err.Error(), name+": ") != failing[name] {
+				t.Errorf("uploadToBackups() error = %q; want %s named %v", err, name, failing[name])
+			}
+		}
+	}
+}
+
+func TestRestoreFallsBack(t *testing.T) {
+	m := newTestMetabox(t)
+	target := m.derivedTargetPath()
+	writeTree(t, target, map[string]string{"a.txt": "a"})
+	item := backupTo(t, m, "fallback", archiveTar)
+	archive := readCached(t, m, m.storedFilename(item))
+
+	// Stores are tried by priority: b is missing the archive, c fails halfway
+	// and a has it.
+	var log []string
+	withArchive := storage.NewMemory()
+	if err := withArchive.Upload(m.storedFilename(item), bytes.NewReader(archive)); err != nil {
+		t.Fatal(err)
+	}
+	m.Config.Backups = []config.BackupConfig{{Name: "a", Priority: 2}, {Name: "b"}, {Name: "c", Priority: 1}}
+	m.Stores = []storage.Storage{
+		&testStore{Storage: withArchive, name: "a", log: &log},
+		&testStore{Storage: storage.NewMemory(), name: "b", log: &log},
+		&testStore{Storage: storage.NewMemory(), name: "c", log: &log, fail: errors.New("connection reset")},
+	}
+
+	removeTree(t, m.derivedCachePath())
+	removeTree(t, target)
+	if err := m.StartRestore(item); err != nil {
+		t.Fatalf("StartRestore() error = %v", err)
+	}
+	if want := []string{"download b", "download c", "download a"}; !reflect.DeepEqual(log, want) {
+		t.Errorf("StartRestore() made calls %q; want %q", log, want)
+	}
+	if got := readCached(t, m, m.storedFilename(item)); !bytes.Equal(got, archive) {
+		t.Errorf("cached archive has %d bytes; want the %d stored", len(got), len(archive))
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(target, "a.txt")); err != nil || string(got) != "a" {
+		t.Errorf("restored a.txt = %q, %v; want %q", got, err, "a")
+	}
+}
+
+func TestRestoreLeavesNoPartialArchive(t *testing.T) {
+	m := newTestMetabox(t)
+	writeTree(t, m.derivedTargetPath(), map[string]string{"a.txt": "a"})
+	item := backupTo(t, m, "partial", archiveTar)
+	archive := readCached(t, m, m.storedFilename(item))
+	removeTree(t, m.derivedCachePath())
+
+	// Were the restore killed midway, the cache must not hold the archive.
+	cached := filepath.Join(m.derivedCachePath(), m.storedFilename(item))
+	var midway error
+	store := &testStore{Storage: storage.NewMemory(), name: "a", fail: errors.New("connection reset"), midway: func() {
+		_, midway = os.Stat(cached)
+	}}
+	if err := store.Storage.Upload(m.storedFilename(item), bytes.NewReader(archive)); err != nil {
+		t.Fatal(err)
+	}
+	m.Config.Backups = []config.BackupConfig{{Name: "a"}}
+	m.Stores = []storage.Storage{store}
+
+	if err := m.StartRestore(item); err == nil {
+		t.Fatalf("StartRestore() with a failing store = nil; want error")
+	}
+	if !os.IsNotExist(midway) {
+		t.Errorf("cached archive during download: %v; want not exist", midway)
+	}
+	infos, err := ioutil.ReadDir(m.derivedCachePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		t.Errorf("cache has %s after failed download; want nothing", info.Name())
+	}
+}
+
+// testStore wraps a Storage to record the uploads and downloads made to it in
+// log, and to fail them with fail after transferring part of the data, at
+// which point midway is called.
+type testStore struct {
+	storage.Storage
+	name   string
+	fail   error
+	midway func()
+
+	mu  sync.Mutex
+	log *[]string
+}
+
+func (s *testStore) record(op string) {
+	if s.log == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*s.log = append(*s.log, op+" "+s.name)
+}
+
+func (s *testStore) Upload(key string, source io.Reader) error {
+	s.record("upload")
+	if s.fail != nil {
+		io.CopyN(ioutil.Discard, source, 4)
+		return s.fail
+	}
+	return s.Storage.Upload(key, source)
+}
+
+func (s *testStore) Download(key string, destination storage.WriterWriterAt) error {
+	s.record("download")
+	if s.fail != nil {
+		destination.Write([]byte("partial"))
+		if s.midway != nil {
+			s.midway()
+		}
+		return s.fail
+	}
+	return s.Storage.Download(key, destination)
+}
+
+// readCached returns the contents of the file called name in the cache of m.
+func readCached(t *testing.T, m *Metabox, name string) []byte {
+	b, err := ioutil.ReadFile(filepath.Join(m.derivedCachePath(), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// newTestDB gives m an empty tracker in its workspace.
+func newTestDB(t *testing.T, m *Metabox) {
+	m.Config.Workspace.VersionsPath = "versions.csv"
+	db, err := tracker.NewSimpleFileDB(m.derivedVersionsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.DB = db
+}
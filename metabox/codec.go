@@ -0,0 +1,284 @@
+package metabox
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/nmcapule/metabox-go/tracker"
+	"github.com/ulikunitz/xz"
+)
+
+// Names of compression codecs, as used in options.compress and recorded in
+// tracker entries.
+const (
+	codecNone = "none"
+	codecGzip = "gzip"
+	codecZstd = "zstd"
+	codecXz   = "xz"
+)
+
+// gzipBlockSize is the size of the blocks that gzip compresses in parallel.
+const gzipBlockSize = 1 << 20
+
+// zstdBlockSize is the size of the blocks that zstd compresses in parallel.
+const zstdBlockSize = 4 << 20
+
+// codec compresses and decompresses archives.
+type codec interface {
+	// Ext returns the extension of archives, e.g. ".tar.gz".
+	Ext() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// newCodec returns the codec called name. Level is codec-specific, where 0
+// selects the default level. Threads is the number of cores gzip and zstd
+// compress with, where 0 means all of them.
+func newCodec(name string, level, threads int) (codec, error) {
+	if threads <= 0 {
+		threads = runtime.GOMAXPROCS(0)
+	}
+
+	switch name {
+	case codecNone:
+		return noneCodec{}, nil
+	case codecGzip, "tgz":
+		if level == 0 {
+			level = pgzip.BestCompression
+		}
+		if level < pgzip.ConstantCompression || level > pgzip.BestCompression {
+			return nil, fmt.Errorf("invalid gzip level: %d", level)
+		}
+		return gzipCodec{level: level, threads: threads}, nil
+	case codecZstd:
+		return zstdCodec{level: level, threads: threads}, nil
+	case codecXz:
+		return xzCodec{}, nil
+	default:
+		return nil, fmt.Errorf("unknown compression codec: %q", name)
+	}
+}
+
+// codecName returns the canonical name of the configured codec. Gzip is the
+// default.
+func (m *Metabox) codecName() string {
+	switch name := m.Config.Workspace.Options.Compress; name {
+	case "", "tgz":
+		return codecGzip
+	default:
+		return name
+	}
+}
+
+// codecOf returns the codec that compressed the archive of item. Entries
+// without a recorded codec were compressed with gzip.
+func (m *Metabox) codecOf(item *tracker.Item) (codec, error) {
+	name := item.Meta[tracker.MetaCompress]
+	if name == "" {
+		name = codecGzip
+	}
+
+	// The configured level only applies to the configured codec.
+	level := 0
+	if name == m.codecName() {
+		level = m.Config.Workspace.Options.CompressLevel
+	}
+	return newCodec(name, level, m.Config.Workspace.Options.CompressThreads)
+}
+
+type noneCodec struct{}
+
+func (noneCodec) Ext() string {
+	return ".tar"
+}
+
+func (noneCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+func (noneCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(r), nil
+}
+
+// gzipCodec compresses blocks of the archive in parallel. The output is a
+// single standard gzip stream.
+type gzipCodec struct {
+	level   int
+	threads int
+}
+
+func (gzipCodec) Ext() string {
+	return ".tar.gz"
+}
+
+func (c gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	gzw, err := pgzip.NewWriterLevel(w, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if err := gzw.SetConcurrency(gzipBlockSize, c.threads); err != nil {
+		return nil, err
+	}
+	return gzw, nil
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// zstdCodec compresses blocks of the archive in parallel, each to its own
+// zstd frame. The output is a standard zstd stream of concatenated frames, and
+// does not depend on the number of threads.
+type zstdCodec struct {
+	level   int
+	threads int
+}
+
+func (zstdCodec) Ext() string {
+	return ".tar.zst"
+}
+
+func (c zstdCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	// Each encoder serves one EncodeAll call at a time.
+	opts := []zstd.EOption{zstd.WithEncoderConcurrency(c.threads), zstd.WithZeroFrames(true)}
+	if c.level != 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.level)))
+	}
+	enc, err := zstd.NewWriter(nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	zw := &zstdWriter{
+		enc:     enc,
+		w:       w,
+		pending: make(chan chan []byte, c.threads),
+		done:    make(chan struct{}),
+	}
+	go zw.flush()
+	return zw, nil
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return zr.IOReadCloser(), nil
+}
+
+// zstdWriter splits its input into blocks of zstdBlockSize that are encoded
+// concurrently, and writes the frames in order.
+type zstdWriter struct {
+	enc *zstd.Encoder
+	w   io.Writer
+	buf []byte
+	// blocks counts the blocks encoded so far.
+	blocks int
+	// pending holds the frames being encoded, in order. Its capacity bounds
+	// the number of blocks in memory.
+	pending chan chan []byte
+	// done is closed once all pending frames are written.
+	done chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func (zw *zstdWriter) Write(p []byte) (int, error) {
+	if err := zw.error(); err != nil {
+		return 0, err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		if zw.buf == nil {
+			zw.buf = make([]byte, 0, zstdBlockSize)
+		}
+		m := copy(zw.buf[len(zw.buf):cap(zw.buf)], p)
+		zw.buf = zw.buf[:len(zw.buf)+m]
+		p = p[m:]
+		if len(zw.buf) == cap(zw.buf) {
+			zw.encode()
+		}
+	}
+	return n, nil
+}
+
+// encode starts encoding the buffered block.
+func (zw *zstdWriter) encode() {
+	block := zw.buf
+	zw.buf = nil
+	zw.blocks++
+	frame := make(chan []byte, 1)
+	zw.pending <- frame
+	go func() {
+		frame <- zw.enc.EncodeAll(block, make([]byte, 0, len(block)/2))
+	}()
+}
+
+// flush writes the pending frames in order until the writer is closed.
+func (zw *zstdWriter) flush() {
+	defer close(zw.done)
+	for frame := range zw.pending {
+		b := <-frame
+		if zw.error() != nil {
+			continue
+		}
+		if _, err := zw.w.Write(b); err != nil {
+			zw.mu.Lock()
+			zw.err = err
+			zw.mu.Unlock()
+		}
+	}
+}
+
+func (zw *zstdWriter) error() error {
+	zw.mu.Lock()
+	defer zw.mu.Unlock()
+	return zw.err
+}
+
+// Close encodes the rest of the input and waits for all frames to be written.
+// Empty input is written as an empty frame.
+func (zw *zstdWriter) Close() error {
+	if len(zw.buf) > 0 || zw.blocks == 0 {
+		zw.encode()
+	}
+	close(zw.pending)
+	<-zw.done
+	zw.enc.Close()
+	return zw.error()
+}
+
+type xzCodec struct{}
+
+func (xzCodec) Ext() string {
+	return ".tar.xz"
+}
+
+func (xzCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return xz.NewWriter(w)
+}
+
+func (xzCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	xr, err := xz.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(xr), nil
+}
+
+// nopWriteCloser adds a no-op Close to an io.Writer.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
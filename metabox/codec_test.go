@@ -0,0 +1,139 @@
+package metabox
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/nmcapule/metabox-go/config"
+)
+
+func TestCodecName(t *testing.T) {
+	tests := []struct {
+		compress string
+		want     string
+		wantExt  string
+	}{
+		{"", codecGzip, ".tar.gz"},
+		{"tgz", codecGzip, ".tar.gz"},
+		{codecGzip, codecGzip, ".tar.gz"},
+		{codecZstd, codecZstd, ".tar.zst"},
+		{codecXz, codecXz, ".tar.xz"},
+		{codecNone, codecNone, ".tar"},
+	}
+	for _, tt := range tests {
+		m := &Metabox{Config: &config.Config{}}
+		m.Config.Workspace.Options.Compress = tt.compress
+
+		name := m.codecName()
+		if name != tt.want {
+			t.Errorf("codecName() with compress %q = %q; want %q", tt.compress, name, tt.want)
+		}
+		c, err := newCodec(name, 0, 0)
+		if err != nil {
+			t.Errorf("newCodec(%q) error = %v", name, err)
+			continue
+		}
+		if ext := c.Ext(); ext != tt.wantExt {
+			t.Errorf("newCodec(%q).Ext() = %q; want %q", name, ext, tt.wantExt)
+		}
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	want := bytes.Repeat([]byte("metabox "), 1<<18)
+	for _, name := range []string{codecNone, codecGzip, codecZstd, codecXz} {
+		c, err := newCodec(name, 0, 0)
+		if err != nil {
+			t.Fatalf("newCodec(%q) error = %v", name, err)
+		}
+
+		var buf bytes.Buffer
+		w, err := c.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("%s: NewWriter() error = %v", name, err)
+		}
+		if _, err := w.Write(want); err != nil {
+			t.Fatalf("%s: Write() error = %v", name, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: Close() error = %v", name, err)
+		}
+
+		r, err := c.NewReader(&buf)
+		if err != nil {
+			t.Fatalf("%s: NewReader() error = %v", name, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s: read back %d bytes, %v; want %d bytes", name, len(got), err, len(want))
+		}
+	}
+}
+
+func TestZstdThreads(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	want := make([]byte, 2*zstdBlockSize+12345)
+	for i := range want {
+		want[i] = "metabox "[rnd.Intn(8)]
+	}
+
+	var outputs [][]byte
+	for _, threads := range []int{1, 4} {
+		c, err := newCodec(codecZstd, 0, threads)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		w, err := c.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter() error = %v", err)
+		}
+		// Write in odd sizes to straddle blocks.
+		for p := want; len(p) > 0; {
+			n := 100003
+			if n > len(p) {
+				n = len(p)
+			}
+			if _, err := w.Write(p[:n]); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			p = p[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+		outputs = append(outputs, buf.Bytes())
+
+		r, err := c.NewReader(&buf)
+		if err != nil {
+			t.Fatalf("NewReader() error = %v", err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("threads=%d: read back %d bytes, %v; want %d bytes", threads, len(got), err, len(want))
+		}
+	}
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Errorf("output with 1 thread differs from output with 4 threads")
+	}
+
+	// Empty input is still a valid stream.
+	c, _ := newCodec(codecZstd, 0, 2)
+	var buf bytes.Buffer
+	w, _ := c.NewWriter(&buf)
+	if err := w.Close(); err != nil || buf.Len() == 0 {
+		t.Fatalf("Close() of empty writer wrote %d bytes, %v; want a frame", buf.Len(), err)
+	}
+	r, err := c.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadAll(r); err != nil || len(got) != 0 {
+		t.Errorf("read back %q, %v from empty stream; want nothing", got, err)
+	}
+	r.Close()
+}
@@ -2,15 +2,16 @@ package metabox
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+
+	"github.com/nmcapule/metabox-go/tracker"
 )
 
-func (m *Metabox) compress(filepaths []string, name string) error {
+func (m *Metabox) compress(filepaths []string, item *tracker.Item) error {
 	target, err := filepath.Abs(m.derivedTargetPath())
 	if err != nil {
 		return fmt.Errorf("retrieving absolute path: %v", err)
@@ -23,56 +24,95 @@ func (m *Metabox) compress(filepaths []string, name string) error {
 	}
 
 	// Create target output file.
-	outpath := filepath.FromSlash(filepath.Join(cachepath, m.compressedFilename(name)))
+	outpath := filepath.FromSlash(filepath.Join(cachepath, m.compressedFilename(item)))
 	file, err := os.OpenFile(outpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return fmt.Errorf("creating tmp file: %v", err)
 	}
 	defer file.Close()
 
-	// Declare our gzip and tar writer.
-	gzw, err := gzip.NewWriterLevel(file, gzip.BestCompression)
+	// Declare our archive writer.
+	aw, err := m.newArchiveWriter(item, file)
 	if err != nil {
-		return fmt.Errorf("creating gzip writer: %v", err)
+		return err
 	}
-	defer gzw.Close()
-
-	tw := tar.NewWriter(gzw)
-	defer tw.Close()
 
 	// Compress each file!
 	for _, path := range filepaths {
-		body, err := ioutil.ReadFile(path)
-		if err != nil {
-			return fmt.Errorf("reading %s: %v", path, err)
-		}
-
-		// Early exit on nil body.
-		if body == nil {
-			continue
-		}
-
 		// Declare relative file path.
 		rel, err := filepath.Rel(target, path)
 		if err != nil {
+			aw.Close()
 			return fmt.Errorf("relpath of %s: %v", path, err)
 		}
 
 		log.Printf("compress %s", rel)
 
-		// Do the write to tar.gz!
-		hdr := &tar.Header{
-			Name: rel,
-			Mode: int64(0644),
-			Size: int64(len(body)),
+		if err := addFile(aw, path, filepath.ToSlash(rel)); err != nil {
+			aw.Close()
+			return err
 		}
-		if err := tw.WriteHeader(hdr); err != nil {
-			return fmt.Errorf("writing headers: %v", err)
+	}
+
+	if err := aw.Close(); err != nil {
+		return fmt.Errorf("finishing archive: %v", err)
+	}
+	return nil
+}
+
+// addFile writes the file, directory or symbolic link at path to aw as
+// name, along with its mode, mtime and ownership.
+func addFile(aw archiveWriter, path, name string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("stat %s: %v", path, err)
+	}
+
+	// Directories and symbolic links have no contents.
+	if !info.Mode().IsRegular() {
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return fmt.Errorf("reading link %s: %v", path, err)
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return fmt.Errorf("header of %s: %v", path, err)
+		}
+		hdr.Name = name
+		if info.IsDir() {
+			hdr.Name += "/"
 		}
-		if _, err := tw.Write(body); err != nil {
-			return fmt.Errorf("writing body: %v", err)
+		if _, err := aw.Create(hdr); err != nil {
+			return fmt.Errorf("writing headers: %v", err)
 		}
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", path, err)
 	}
+	defer f.Close()
 
+	info, err = f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat %s: %v", path, err)
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return fmt.Errorf("header of %s: %v", path, err)
+	}
+	hdr.Name = name
+
+	w, err := aw.Create(hdr)
+	if err != nil {
+		return fmt.Errorf("writing headers: %v", err)
+	}
+	// Only copy the size in the header in case the file grew since.
+	if _, err := io.CopyN(w, f, hdr.Size); err != nil {
+		return fmt.Errorf("writing body: %v", err)
+	}
 	return nil
 }
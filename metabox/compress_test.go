@@ -0,0 +1,108 @@
+package metabox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nmcapule/metabox-go/config"
+	"github.com/nmcapule/metabox-go/tracker"
+)
+
+// Shape of the synthetic tree used by BenchmarkCompress, 32 MiB in total.
+const (
+	benchDirs     = 16
+	benchFiles    = 32
+	benchFileSize = 64 << 10
+)
+
+func BenchmarkCompress(b *testing.B) {
+	root, paths, size := writeBenchTree(b)
+
+	benchmarks := []struct {
+		name     string
+		archive  string
+		compress string
+		threads  int
+	}{
+		{"tar", archiveTar, codecNone, 0},
+		{"tar.gz/threads=1", archiveTar, codecGzip, 1},
+		{"tar.gz/threads=all", archiveTar, codecGzip, 0},
+		{"tar.zst/threads=1", archiveTar, codecZstd, 1},
+		{"tar.zst/threads=all", archiveTar, codecZstd, 0},
+		{"tar.xz", archiveTar, codecXz, 0},
+		{"zip", archiveZip, "", 0},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			m := &Metabox{Config: &config.Config{}}
+			m.Config.Workspace.Options.CompressThreads = bm.threads
+			item := &tracker.Item{}
+			item.SetMeta(tracker.MetaArchive, bm.archive)
+			item.SetMeta(tracker.MetaCompress, bm.compress)
+
+			b.SetBytes(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				aw, err := m.newArchiveWriter(item, ioutil.Discard)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, path := range paths {
+					rel, _ := filepath.Rel(root, path)
+					if err := addFile(aw, path, filepath.ToSlash(rel)); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := aw.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// writeBenchTree writes a tree of text-like files that compress about as well
+// as source code, and returns its root, its files and their total size.
+func writeBenchTree(b *testing.B) (string, []string, int64) {
+	root, err := ioutil.TempDir("", "metabox-bench-")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { os.RemoveAll(root) })
+
+	words := strings.Fields("func return if err != nil { } package import metabox storage archive " +
+		"config tracker item key value string int64 error fmt.Errorf for range := nil true false")
+	rnd := rand.New(rand.NewSource(1))
+
+	var paths []string
+	var size int64
+	for d := 0; d < benchDirs; d++ {
+		dir := filepath.Join(root, fmt.Sprintf("dir%02d", d))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			b.Fatal(err)
+		}
+		for f := 0; f < benchFiles; f++ {
+			var sb strings.Builder
+			for sb.Len() < benchFileSize {
+				sb.WriteString(words[rnd.Intn(len(words))])
+				if rnd.Intn(8) == 0 {
+					sb.WriteByte('\n')
+				} else {
+					sb.WriteByte(' ')
+				}
+			}
+			path := filepath.Join(dir, fmt.Sprintf("file%02d.go", f))
+			if err := ioutil.WriteFile(path, []byte(sb.String()), 0644); err != nil {
+				b.Fatal(err)
+			}
+			paths = append(paths, path)
+			size += int64(sb.Len())
+		}
+	}
+	return root, paths, size
+}
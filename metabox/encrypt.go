@@ -0,0 +1,128 @@
+package metabox
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/nmcapule/metabox-go/storage"
+	"github.com/nmcapule/metabox-go/tracker"
+)
+
+// encryptedExt is appended to the filename of encrypted archives.
+const encryptedExt = ".age"
+
+// recipientsExt is appended to the filename of encrypted archives to name the
+// file listing the recipients they were encrypted to.
+const recipientsExt = ".recipients"
+
+// encrypt encrypts the cached archive to each of the age recipients, and
+// writes it next to the archive with an extra encryptedExt extension.
+//
+// An encrypted archive already cached for the same recipients is kept as is:
+// age encrypts with a new random key every time, so encrypting again would
+// change every byte and keep an interrupted upload from resuming.
+func (m *Metabox) encrypt(item *tracker.Item, recipients []string) error {
+	var rs []age.Recipient
+	for _, recipient := range recipients {
+		r, err := age.ParseX25519Recipient(recipient)
+		if err != nil {
+			return fmt.Errorf("parsing recipient %q: %v", recipient, err)
+		}
+		rs = append(rs, r)
+	}
+
+	inpath := filepath.Join(m.derivedCachePath(), m.compressedFilename(item))
+	outpath := inpath + encryptedExt
+	list := []byte(strings.Join(recipients, "\n") + "\n")
+	if cached, err := ioutil.ReadFile(outpath + recipientsExt); err == nil && bytes.Equal(cached, list) {
+		if _, err := os.Stat(outpath); err == nil {
+			return nil
+		}
+	}
+
+	in, err := os.Open(inpath)
+	if err != nil {
+		return fmt.Errorf("opening %q: %v", inpath, err)
+	}
+	defer in.Close()
+
+	// Drop the recipients first, so that a half-replaced archive is never
+	// mistaken for one encrypted to them.
+	if err := os.Remove(outpath + recipientsExt); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = storage.WriteFileAtomic(outpath, func(out *os.File) error {
+		w, err := age.Encrypt(out, rs...)
+		if err != nil {
+			return fmt.Errorf("encrypting %q: %v", inpath, err)
+		}
+		if _, err := io.Copy(w, in); err != nil {
+			return fmt.Errorf("encrypting %q: %v", inpath, err)
+		}
+		return w.Close()
+	})
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(outpath+recipientsExt, func(out *os.File) error {
+		_, err := out.Write(list)
+		return err
+	})
+}
+
+// decrypt decrypts the cached encrypted archive with the configured identity
+// file, and writes the plain archive to the cache.
+func (m *Metabox) decrypt(item *tracker.Item) error {
+	identities, err := m.identities()
+	if err != nil {
+		return err
+	}
+
+	outpath := filepath.Join(m.derivedCachePath(), m.compressedFilename(item))
+	inpath := outpath + encryptedExt
+	in, err := os.Open(inpath)
+	if err != nil {
+		return fmt.Errorf("opening %q: %v", inpath, err)
+	}
+	defer in.Close()
+
+	return storage.WriteFileAtomic(outpath, func(out *os.File) error {
+		r, err := age.Decrypt(in, identities...)
+		if err != nil {
+			return fmt.Errorf("decrypting %q: %v", inpath, err)
+		}
+		if _, err := io.Copy(out, r); err != nil {
+			return fmt.Errorf("decrypting %q: %v", inpath, err)
+		}
+		return nil
+	})
+}
+
+// identities reads the age identities from the configured identity file.
+func (m *Metabox) identities() ([]age.Identity, error) {
+	path := m.Config.Workspace.Encryption.IdentityFile
+	if path == "" {
+		return nil, fmt.Errorf("encryption.identity_file is required to restore encrypted backups")
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(m.Config.Workspace.RootPath, path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening identity file: %v", err)
+	}
+	defer file.Close()
+
+	identities, err := age.ParseIdentities(file)
+	if err != nil {
+		return nil, fmt.Errorf("parsing identity file %q: %v", path, err)
+	}
+	return identities, nil
+}
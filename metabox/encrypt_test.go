@@ -0,0 +1,153 @@
+package metabox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/nmcapule/metabox-go/config"
+	"github.com/nmcapule/metabox-go/storage"
+	"github.com/nmcapule/metabox-go/tracker"
+)
+
+func TestBackupResumesEncrypted(t *testing.T) {
+	m := newTestMetabox(t)
+	writeTree(t, m.derivedTargetPath(), map[string]string{"a.txt": "a"})
+	newTestDB(t, m)
+	m.Config.Workspace.Encryption.Recipients = []string{newTestIdentity(t).Recipient().String()}
+
+	// The first upload fails, so the backup is retried.
+	store := &testStore{Storage: storage.NewMemory(), name: "a", fail: errors.New("connection reset")}
+	m.Config.Backups = []config.BackupConfig{{Name: "a"}}
+	m.Stores = []storage.Storage{store}
+	if _, err := m.StartBackup(); err == nil {
+		t.Fatalf("StartBackup() with a failing store = nil; want error")
+	}
+	paths, err := m.walk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := m.hash(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := &tracker.Item{ID: fmt.Sprintf("%x", sum)}
+	item.SetMeta(tracker.MetaArchive, archiveTar)
+	item.SetMeta(tracker.MetaCompress, codecGzip)
+	item.SetRecipients(m.Config.Workspace.Encryption.Recipients)
+	first := readCached(t, m, m.storedFilename(item))
+
+	// Resuming needs the upload to send the same bytes again.
+	store.fail = nil
+	item, err = m.StartBackup()
+	if err != nil {
+		t.Fatalf("StartBackup() error = %v", err)
+	}
+	if got := readCached(t, m, m.storedFilename(item)); !bytes.Equal(got, first) {
+		t.Errorf("StartBackup() encrypted the cached archive again")
+	}
+	var got storage.Buffer
+	if err := store.Storage.Download(m.storedFilename(item), &got); err != nil || !bytes.Equal(got.Bytes(), first) {
+		t.Errorf("uploaded %d bytes, %v; want the %d cached", len(got.Bytes()), err, len(first))
+	}
+
+	// The archive is encrypted again for other recipients.
+	recipients := []string{newTestIdentity(t).Recipient().String()}
+	if err := m.encrypt(item, recipients); err != nil {
+		t.Fatalf("encrypt() error = %v", err)
+	}
+	if bytes.Equal(readCached(t, m, m.storedFilename(item)), first) {
+		t.Errorf("encrypt() for other recipients kept the cached archive")
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		// identities are written to the identity file, if any.
+		identities []*age.X25519Identity
+		wantErr    bool
+	}{
+		{"Recipient", []*age.X25519Identity{nil}, false},
+		{"AmongOthers", []*age.X25519Identity{newTestIdentity(t), nil}, false},
+		{"WrongIdentity", []*age.X25519Identity{newTestIdentity(t)}, true},
+		{"NoIdentityFile", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMetabox(t)
+			target := m.derivedTargetPath()
+			writeTree(t, target, map[string]string{"a.txt": "secret"})
+			item := backupTo(t, m, "encrypted", archiveTar)
+			plain := readCached(t, m, m.compressedFilename(item))
+
+			identity := newTestIdentity(t)
+			recipients := []string{newTestIdentity(t).Recipient().String(), identity.Recipient().String()}
+			if err := m.encrypt(item, recipients); err != nil {
+				t.Fatalf("encrypt() error = %v", err)
+			}
+			item.SetRecipients(recipients)
+			encrypted := readCached(t, m, m.storedFilename(item))
+			if bytes.Contains(encrypted, plain) {
+				t.Fatalf("encrypted archive contains the plain archive")
+			}
+
+			if tt.identities != nil {
+				var lines []string
+				for _, id := range tt.identities {
+					if id == nil {
+						id = identity
+					}
+					lines = append(lines, id.String())
+				}
+				if err := ioutil.WriteFile(filepath.Join(m.Config.Workspace.RootPath, "key.txt"), []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+					t.Fatal(err)
+				}
+				m.Config.Workspace.Encryption.IdentityFile = "key.txt"
+			}
+
+			// Restore from a store that has the encrypted archive only.
+			store := storage.NewMemory()
+			if err := store.Upload(m.storedFilename(item), bytes.NewReader(encrypted)); err != nil {
+				t.Fatal(err)
+			}
+			m.Config.Backups = []config.BackupConfig{{Name: "a"}}
+			m.Stores = []storage.Storage{store}
+			removeTree(t, m.derivedCachePath())
+			removeTree(t, target)
+
+			err := m.StartRestore(item)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("StartRestore() error = %v; want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				// Nothing is extracted, nor left in the cache as if decrypted.
+				if _, err := os.Stat(filepath.Join(target, "a.txt")); !os.IsNotExist(err) {
+					t.Errorf("a.txt after failed restore: %v; want not exist", err)
+				}
+				if _, err := os.Stat(filepath.Join(m.derivedCachePath(), m.compressedFilename(item))); !os.IsNotExist(err) {
+					t.Errorf("plain archive after failed restore: %v; want not exist", err)
+				}
+				return
+			}
+			if got, err := ioutil.ReadFile(filepath.Join(target, "a.txt")); err != nil || string(got) != "secret" {
+				t.Errorf("restored a.txt = %q, %v; want %q", got, err, "secret")
+			}
+		})
+	}
+}
+
+// newTestIdentity generates a new age identity.
+func newTestIdentity(t *testing.T) *age.X25519Identity {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity
+}
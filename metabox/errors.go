@@ -1,8 +1,29 @@
 package metabox
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 var (
 	errCacheNotFound     = errors.New("cache not found")
 	errNoAvailableStores = errors.New("no available stores")
 )
+
+// storeError is an error from an operation on a named store.
+type storeError struct {
+	store string
+	err   error
+}
+
+// storeErrors collects the failures of an operation across multiple stores.
+type storeErrors []storeError
+
+func (e storeErrors) Error() string {
+	var msgs []string
+	for _, se := range e {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", se.store, se.err))
+	}
+	return strings.Join(msgs, "; ")
+}
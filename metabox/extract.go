@@ -2,63 +2,263 @@ package metabox
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/nmcapule/metabox-go/tracker"
 )
 
-func (m *Metabox) extract(name string) error {
+func (m *Metabox) extract(item *tracker.Item) error {
 	target, err := filepath.Abs(m.derivedTargetPath())
 	if err != nil {
 		return fmt.Errorf("retrieving absolute path: %v", err)
 	}
 
 	// Make sure cachepath exists.
-	cache := filepath.FromSlash(filepath.Join(m.derivedCachePath(), m.compressedFilename(name)))
+	cache := filepath.FromSlash(filepath.Join(m.derivedCachePath(), m.compressedFilename(item)))
 	cachefile, err := os.OpenFile(cache, os.O_RDONLY, 0644)
 	if err != nil {
 		return fmt.Errorf("opening cache file: %v", err)
 	}
 	defer cachefile.Close()
 
-	gzr, err := gzip.NewReader(cachefile)
+	ar, err := m.newArchiveReader(item, cachefile)
 	if err != nil {
-		return fmt.Errorf("creating gzip reader from %q: %v", cache, err)
+		return fmt.Errorf("reading %q: %v", cache, err)
 	}
+	defer ar.Close()
 
-	tr := tar.NewReader(gzr)
+	// Symbolic links may already lead elsewhere, so compare real paths.
+	realTarget, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return fmt.Errorf("resolving %q: %v", target, err)
+	}
+
+	// Directories get their metadata last, as extracting entries into them
+	// updates their mtimes.
+	var dirs []*tar.Header
 	for {
-		hdr, err := tr.Next()
+		hdr, body, err := ar.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("extract tar: %v", err)
+			return fmt.Errorf("extract %s: %v", archiveFormat(item), err)
 		}
 
 		// Calculate extract path of the new file or directory.
-		path := filepath.Join(target, hdr.Name)
+		path := filepath.Join(target, filepath.FromSlash(hdr.Name))
+		dir, err := realPath(filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		if !within(realTarget, dir) {
+			return fmt.Errorf("extract %q: outside of target", hdr.Name)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("mkdir %q: %v", dir, err)
+		}
 
 		switch hdr.Typeflag {
 		case tar.TypeDir:
-			if err := os.Mkdir(path, 0755); err != nil {
+			if err := os.MkdirAll(path, 0755); err != nil {
 				return fmt.Errorf("mkdir %q: %v", path, err)
 			}
+			// Keep the directory writable until its own mode is applied,
+			// e.g. when restoring over a read-only directory.
+			if info, err := os.Stat(path); err != nil {
+				return fmt.Errorf("stat %q: %v", path, err)
+			} else if perm := info.Mode().Perm(); perm&0300 != 0300 {
+				if err := os.Chmod(path, perm|0300); err != nil {
+					return fmt.Errorf("chmod %q: %v", path, err)
+				}
+			}
+			dirs = append(dirs, hdr)
+			continue
 		case tar.TypeReg:
-			out, err := os.Create(path)
+			// Replace rather than write through existing files, which may be
+			// read-only or symbolic links.
+			if err := removeFile(path); err != nil {
+				return err
+			}
+			if err := writeFile(path, body, hdr.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			dest, err := linkDest(dir, hdr.Linkname)
 			if err != nil {
-				return fmt.Errorf("create %q: %v", path, err)
+				log.Printf("extract: refusing %s -> %s: %v", hdr.Name, hdr.Linkname, err)
+				continue
+			}
+			if !within(realTarget, dest) {
+				log.Printf("extract: refusing %s -> %s: points outside of target", hdr.Name, hdr.Linkname)
+				continue
 			}
-			if _, err := io.Copy(out, tr); err != nil {
-				return fmt.Errorf("copy %q: %v", path, err)
+			if err := removeFile(path); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return fmt.Errorf("symlink %q: %v", path, err)
 			}
-			out.Close()
 		default:
 			return fmt.Errorf("unknown type %q (%q)", hdr.Typeflag, hdr.Name)
 		}
+
+		if err := m.applyHeader(path, hdr); err != nil {
+			return err
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		path := filepath.Join(target, filepath.FromSlash(dirs[i].Name))
+		if err := m.applyHeader(path, dirs[i]); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
+
+// within reports whether path is root or inside it.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// realPath resolves the symbolic links in path. Parts of path that do not
+// exist yet are kept as is.
+func realPath(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		return resolved, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("resolving %q: %v", path, err)
+	}
+
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path, nil
+	}
+	resolved, err = realPath(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolved, filepath.Base(path)), nil
+}
+
+// linkDest returns where a symbolic link in dir to link would lead. Like the
+// kernel, it follows links that already exist on disk before applying "..",
+// e.g. "b/.." leads to the parent of b's target rather than to dir.
+func linkDest(dir, link string) (string, error) {
+	dest := dir
+	if filepath.IsAbs(link) {
+		dest = string(filepath.Separator)
+	}
+	for _, part := range strings.Split(filepath.ToSlash(link), "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			dest = filepath.Dir(dest)
+			continue
+		}
+		dest = filepath.Join(dest, part)
+		if info, err := os.Lstat(dest); err != nil || info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(dest)
+		if err != nil {
+			return "", fmt.Errorf("resolving %q: %v", dest, err)
+		}
+		dest = resolved
+	}
+	return dest, nil
+}
+
+// removeFile removes path if it exists and is not a directory.
+func removeFile(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil || info.IsDir() {
+		return nil
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("remove %q: %v", path, err)
+	}
+	return nil
+}
+
+// writeFile writes the contents of r to path, creating it with perm.
+func writeFile(path string, r io.Reader, perm os.FileMode) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("create %q: %v", path, err)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return fmt.Errorf("copy %q: %v", path, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("close %q: %v", path, err)
+	}
+	return nil
+}
+
+// archivedMode are the bits of a file mode that are archived and restored.
+const archivedMode = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// applyHeader gives the extracted path the ownership, mode and mtime recorded
+// in hdr.
+func (m *Metabox) applyHeader(path string, hdr *tar.Header) error {
+	// Change the owner first, as it may clear the setuid and setgid bits.
+	if m.sameOwner() {
+		uid, gid := m.mapOwner(hdr.Uid, hdr.Gid)
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return fmt.Errorf("chown %q: %v", path, err)
+		}
+	}
+
+	// Symbolic links have no mode and their mtime cannot be set portably.
+	if hdr.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+
+	mode := hdr.FileInfo().Mode() & archivedMode
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("chmod %q: %v", path, err)
+	}
+
+	// Archives made before mtimes were recorded have the zero or epoch time.
+	if hdr.ModTime.Unix() > 0 {
+		if err := os.Chtimes(path, hdr.ModTime, hdr.ModTime); err != nil {
+			return fmt.Errorf("chtimes %q: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// sameOwner reports whether restored files keep the ownership recorded in the
+// archive. Like tar, this is the default only for the superuser.
+func (m *Metabox) sameOwner() bool {
+	return !m.Config.Workspace.Options.NoSameOwner && os.Geteuid() == 0
+}
+
+// mapOwner maps the uid and gid recorded in an archive to the ones to restore
+// with. Unknown ids, i.e. -1, are left as is.
+func (m *Metabox) mapOwner(uid, gid int) (int, int) {
+	opts := m.Config.Workspace.Options
+	if mapped, ok := opts.UIDMap[uid]; ok && uid >= 0 {
+		uid = mapped
+	}
+	if mapped, ok := opts.GIDMap[gid]; ok && gid >= 0 {
+		gid = mapped
+	}
+	return uid, gid
+}
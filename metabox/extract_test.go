@@ -0,0 +1,334 @@
+package metabox
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nmcapule/metabox-go/config"
+	"github.com/nmcapule/metabox-go/tracker"
+)
+
+func TestWalkListsParentDirs(t *testing.T) {
+	m := newTestMetabox(t)
+	writeTree(t, m.derivedTargetPath(), map[string]string{
+		"a/b/file.txt": "file",
+		"a/c.txt":      "c",
+		"empty/":       "",
+		"skip/x.log":   "x",
+	})
+	m.Config.Target.Excludes = []string{"skip/"}
+
+	paths, err := m.walk()
+	if err != nil {
+		t.Fatalf("walk() error = %v", err)
+	}
+	got := relPaths(t, m.derivedTargetPath(), paths)
+	want := []string{"a", "a/b", "a/b/file.txt", "a/c.txt", "empty"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("walk() = %q; want %q", got, want)
+	}
+}
+
+func TestHashMode(t *testing.T) {
+	m := newTestMetabox(t)
+	target := m.derivedTargetPath()
+	writeTree(t, target, map[string]string{"dir/file.txt": "file"})
+
+	hash := func() []byte {
+		paths, err := m.walk()
+		if err != nil {
+			t.Fatalf("walk() error = %v", err)
+		}
+		sum, err := m.hash(paths)
+		if err != nil {
+			t.Fatalf("hash() error = %v", err)
+		}
+		return sum
+	}
+	chmod := func(name string, mode os.FileMode) {
+		if err := os.Chmod(filepath.Join(target, name), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := hash()
+	chmod("dir/file.txt", 0755)
+	if bytes.Equal(hash(), before) {
+		t.Errorf("hash() did not change with the mode of a file")
+	}
+	chmod("dir/file.txt", 0644)
+	chmod("dir", 0700)
+	if bytes.Equal(hash(), before) {
+		t.Errorf("hash() did not change with the mode of a directory")
+	}
+	chmod("dir", 0755)
+	if !bytes.Equal(hash(), before) {
+		t.Errorf("hash() changed after restoring the modes")
+	}
+}
+
+func TestRestoreOverReadOnly(t *testing.T) {
+	m := newTestMetabox(t)
+	target := m.derivedTargetPath()
+	writeTree(t, target, map[string]string{"ro/file.txt": "file"})
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, f := range []struct {
+		name string
+		mode os.FileMode
+	}{{"ro/file.txt", 0444}, {"ro", 0555}} {
+		path := filepath.Join(target, f.name)
+		if err := os.Chmod(path, f.mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	item := backupTo(t, m, "readonly", archiveTar)
+
+	// Restore over the read-only originals, then over a fresh target.
+	for _, fresh := range []bool{false, true} {
+		if fresh {
+			removeTree(t, target)
+			if err := os.Mkdir(target, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := m.extract(item); err != nil {
+			t.Fatalf("extract() error = %v", err)
+		}
+
+		if got, err := ioutil.ReadFile(filepath.Join(target, "ro/file.txt")); err != nil || string(got) != "file" {
+			t.Errorf("restored file = %q, %v; want %q", got, err, "file")
+		}
+		for name, want := range map[string]os.FileMode{"ro/file.txt": 0444, "ro": os.ModeDir | 0555} {
+			info, err := os.Stat(filepath.Join(target, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode() != want || !info.ModTime().Equal(mtime) {
+				t.Errorf("restored %s has mode %v and mtime %v; want %v and %v", name, info.Mode(), info.ModTime(), want, mtime)
+			}
+		}
+	}
+}
+
+func TestRoundTripLinksAndEmptyDirs(t *testing.T) {
+	links := map[string]string{"link": "sub/file.txt", "dirlink": "sub", "dangling": "missing"}
+	modes := map[string]os.FileMode{
+		"sub/file.txt": 0644,
+		"sub/run.sh":   0755,
+		"sub/key":      0600,
+		"private":      os.ModeDir | 0700,
+		"empty":        os.ModeDir | 0755,
+	}
+
+	for _, format := range []string{archiveTar, archiveZip} {
+		t.Run(format, func(t *testing.T) {
+			m := newTestMetabox(t)
+			target := m.derivedTargetPath()
+			writeTree(t, target, map[string]string{
+				"sub/file.txt":   "file",
+				"sub/run.sh":     "#!/bin/sh",
+				"sub/key":        "key",
+				"private/secret": "secret",
+				"empty/":         "",
+			})
+			for link, dest := range links {
+				if err := os.Symlink(dest, filepath.Join(target, link)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for name, mode := range modes {
+				if err := os.Chmod(filepath.Join(target, name), mode.Perm()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			item := backupTo(t, m, "links", format)
+			removeTree(t, target)
+			if err := os.Mkdir(target, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.extract(item); err != nil {
+				t.Fatalf("extract() error = %v", err)
+			}
+
+			for link, want := range links {
+				if got, err := os.Readlink(filepath.Join(target, link)); err != nil || got != want {
+					t.Errorf("restored link %s -> %q, %v; want %q", link, got, err, want)
+				}
+			}
+			for name, want := range modes {
+				if info, err := os.Lstat(filepath.Join(target, name)); err != nil {
+					t.Errorf("restored %s: %v", name, err)
+				} else if info.Mode() != want {
+					t.Errorf("restored %s has mode %v; want %v", name, info.Mode(), want)
+				}
+			}
+			if got, err := ioutil.ReadFile(filepath.Join(target, "link")); err != nil || string(got) != "file" {
+				t.Errorf("reading through restored link = %q, %v; want %q", got, err, "file")
+			}
+		})
+	}
+}
+
+func TestExtractRefusesEscapingLinks(t *testing.T) {
+	tests := []struct {
+		name  string
+		links [][2]string
+		// want are the links that are restored, the others are refused.
+		want []string
+	}{
+		{"Parent", [][2]string{{"up", ".."}}, nil},
+		{"Direct", [][2]string{{"up", "../.."}}, nil},
+		{"Absolute", [][2]string{{"etc", "/etc"}}, nil},
+		{"Nested", [][2]string{{"sub/up", "../../x"}}, nil},
+		{"Chained", [][2]string{{"b", "."}, {"a", "b/.."}}, []string{"b"}},
+		{"ChainedDeep", [][2]string{{"c", "sub"}, {"b", "c/.."}, {"a", "b/../.."}}, []string{"c", "b"}},
+		{"Inside", [][2]string{{"b", "sub"}, {"a", "b/../sub/x"}}, []string{"b", "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMetabox(t)
+			target := m.derivedTargetPath()
+
+			hdrs := []*tar.Header{{Name: "sub/", Typeflag: tar.TypeDir, Mode: 0755}}
+			for _, link := range tt.links {
+				hdrs = append(hdrs, &tar.Header{Name: link[0], Typeflag: tar.TypeSymlink, Linkname: link[1], Mode: 0777})
+			}
+			item := writeTestArchive(t, m, hdrs)
+			if err := m.extract(item); err != nil {
+				t.Fatalf("extract() error = %v", err)
+			}
+
+			restored := make(map[string]bool)
+			for _, name := range tt.want {
+				restored[name] = true
+			}
+			for _, link := range tt.links {
+				_, err := os.Lstat(filepath.Join(target, link[0]))
+				if got := err == nil; got != restored[link[0]] {
+					t.Errorf("link %s -> %s restored = %v; want %v", link[0], link[1], got, restored[link[0]])
+				}
+			}
+		})
+	}
+}
+
+// backupTo walks and compresses the target of m into an archive of format.
+func backupTo(t *testing.T, m *Metabox, id, format string) *tracker.Item {
+	paths, err := m.walk()
+	if err != nil {
+		t.Fatalf("walk() error = %v", err)
+	}
+	item := &tracker.Item{ID: id}
+	item.SetMeta(tracker.MetaArchive, format)
+	if format == archiveTar {
+		item.SetMeta(tracker.MetaCompress, codecGzip)
+	}
+	if err := m.compress(paths, item); err != nil {
+		t.Fatalf("compress() error = %v", err)
+	}
+	return item
+}
+
+// writeTestArchive writes an uncompressed tar archive of hdrs to the cache of
+// m, with empty contents for regular files.
+func writeTestArchive(t *testing.T, m *Metabox, hdrs []*tar.Header) *tracker.Item {
+	item := &tracker.Item{ID: "crafted"}
+	item.SetMeta(tracker.MetaArchive, archiveTar)
+	item.SetMeta(tracker.MetaCompress, codecNone)
+
+	if err := os.MkdirAll(m.derivedCachePath(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(m.derivedCachePath(), m.compressedFilename(item)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, hdr := range hdrs {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return item
+}
+
+// newTestMetabox creates a Metabox with an empty target folder and cache in a
+// directory that is removed when t finishes.
+func newTestMetabox(t *testing.T) *Metabox {
+	root, err := ioutil.TempDir("", "metabox-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { removeTree(t, root) })
+
+	m := &Metabox{Config: &config.Config{}}
+	m.Config.Workspace.RootPath = root
+	m.Config.Workspace.CachePath = "cache"
+	m.Config.Target.PrefixPath = "target"
+	if err := os.Mkdir(m.derivedTargetPath(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// writeTree creates the files in tree under root. Names ending in a slash are
+// directories.
+func writeTree(t *testing.T, root string, tree map[string]string) {
+	for name, contents := range tree {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if name[len(name)-1] == '/' {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// removeTree removes root, including read-only directories in it.
+func removeTree(t *testing.T, root string) {
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			os.Chmod(path, 0755)
+		}
+		return nil
+	})
+	if err := os.RemoveAll(root); err != nil {
+		t.Error(err)
+	}
+}
+
+// relPaths returns paths relative to root, with forward slashes.
+func relPaths(t *testing.T, root string, paths []string) []string {
+	var rels []string
+	for _, path := range paths {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rels = append(rels, filepath.ToSlash(rel))
+	}
+	return rels
+}
@@ -9,8 +9,15 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+
+	"github.com/nmcapule/metabox-go/tracker"
 )
 
+// hashVersion is recorded in new backups as their tracker.MetaHashVersion.
+// Version 2 added modes, directories and symbolic links to the hash, which
+// changed the hash of every tree.
+const hashVersion = "2"
+
 func (m *Metabox) hash(filepaths []string) ([]byte, error) {
 	target, err := filepath.Abs(m.Config.Target.PrefixPath)
 	if err != nil {
@@ -19,11 +26,9 @@ func (m *Metabox) hash(filepaths []string) ([]byte, error) {
 
 	// Declare our hasher accumulator.
 	var hasher hash.Hash
-	switch m.Config.Workspace.Options.Hash {
+	switch m.hashAlgorithm() {
 	case "md5":
 		hasher = md5.New()
-	case "sha256":
-		fallthrough
 	default:
 		hasher = sha256.New()
 	}
@@ -36,29 +41,89 @@ func (m *Metabox) hash(filepaths []string) ([]byte, error) {
 			return nil, fmt.Errorf("relpath of %s: %v", path, err)
 		}
 
-		f, err := os.Open(path)
+		info, err := os.Lstat(path)
 		if err != nil {
-			return nil, fmt.Errorf("opening %s: %v", rel, err)
+			return nil, fmt.Errorf("stat %s: %v", rel, err)
 		}
 
 		// Add relative file path to hash accumulator.
 		if _, err := hasher.Write([]byte(rel)); err != nil {
 			return nil, fmt.Errorf("hashing %s: %v", rel, err)
 		}
-		// Add file contents to hash accumulator.
-		if _, err := io.Copy(hasher, bufio.NewReader(f)); err != nil {
-			return nil, fmt.Errorf("hashing %s: %v", rel, err)
+
+		// Add the mode, as it is restored too. Symbolic links have none.
+		if info.Mode()&os.ModeSymlink == 0 {
+			if _, err := fmt.Fprintf(hasher, "\x00%o", info.Mode()&archivedMode); err != nil {
+				return nil, fmt.Errorf("hashing %s: %v", rel, err)
+			}
+		}
+
+		switch {
+		case info.IsDir():
+			// Mark directories so they differ from empty files.
+			if _, err := hasher.Write([]byte("/")); err != nil {
+				return nil, fmt.Errorf("hashing %s: %v", rel, err)
+			}
+		case info.Mode()&os.ModeSymlink != 0:
+			// Add where the link points to rather than what it points to.
+			link, err := os.Readlink(path)
+			if err != nil {
+				return nil, fmt.Errorf("reading link %s: %v", rel, err)
+			}
+			if _, err := hasher.Write([]byte("\x00" + link)); err != nil {
+				return nil, fmt.Errorf("hashing %s: %v", rel, err)
+			}
+		default:
+			if err := hashFile(hasher, path); err != nil {
+				return nil, fmt.Errorf("hashing %s: %v", rel, err)
+			}
 		}
 	}
 
 	return hasher.Sum(nil), nil
 }
 
-func (m *Metabox) compressedFilename(sum string) string {
-	switch m.Config.Workspace.Options.Compress {
-	case "tgz":
-		fallthrough
+// hashFile adds the contents of the file at path to hasher.
+func hashFile(hasher hash.Hash, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(hasher, bufio.NewReader(f))
+	return err
+}
+
+// hashAlgorithm returns the name of the algorithm used to hash target files.
+func (m *Metabox) hashAlgorithm() string {
+	switch m.Config.Workspace.Options.Hash {
+	case "md5":
+		return "md5"
 	default:
-		return fmt.Sprintf("%s.tar.gz", sum)
+		return "sha256"
+	}
+}
+
+// compressedFilename returns the filename of the item's archive in the cache,
+// with the extension of its archive format and codec.
+func (m *Metabox) compressedFilename(item *tracker.Item) string {
+	if archiveFormat(item) == archiveZip {
+		return item.ID + ".zip"
+	}
+	c, err := m.codecOf(item)
+	if err != nil {
+		c = gzipCodec{}
+	}
+	return item.ID + c.Ext()
+}
+
+// storedFilename returns the filename of the item's archive as uploaded to the
+// stores, which differs from the compressed filename if it is encrypted.
+func (m *Metabox) storedFilename(item *tracker.Item) string {
+	filename := m.compressedFilename(item)
+	if len(item.Recipients()) > 0 {
+		filename += encryptedExt
 	}
+	return filename
 }
@@ -0,0 +1,98 @@
+package metabox
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/nmcapule/metabox-go/config"
+	"github.com/nmcapule/metabox-go/tracker"
+)
+
+// keyData is the data passed to the key template of a store.
+type keyData struct {
+	// Project defaults to the name of the workspace root directory.
+	Project string
+	Author  string
+	// Created is in UTC so that every machine renders the same key.
+	Created time.Time
+	ID      string
+	Tags    []string
+	// Filename is the default key, e.g. `<hash>.tar.gz`.
+	Filename string
+	// Ext is the extension of Filename, e.g. `.tar.gz`.
+	Ext string
+}
+
+// parseKeyTemplates parses the key template of each backup entry. Entries
+// without one have a nil template. Rendered keys are recorded by store name, so
+// entries with a template must be named: the default name changes with the
+// position of the entry.
+func parseKeyTemplates(backups []config.BackupConfig) ([]*template.Template, error) {
+	templates := make([]*template.Template, len(backups))
+	for i, backup := range backups {
+		if backup.KeyTemplate == "" {
+			continue
+		}
+		if backup.Name == "" {
+			return nil, fmt.Errorf("backup #%d: name is required with key_template", i)
+		}
+		tmpl, err := template.New(fmt.Sprintf("key_template#%d", i)).Parse(backup.KeyTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("parsing key template of backup #%d: %v", i, err)
+		}
+		templates[i] = tmpl
+	}
+	return templates, nil
+}
+
+// assignKeys renders and records the key of item's archive in each store with
+// a key template that has no key recorded yet. It reports whether item
+// changed.
+func (m *Metabox) assignKeys(item *tracker.Item) (bool, error) {
+	filename := m.storedFilename(item)
+	data := keyData{
+		Project:  m.Config.Workspace.Project,
+		Author:   item.Author,
+		Created:  time.Time(item.Created).UTC(),
+		ID:       item.ID,
+		Tags:     item.Tags,
+		Filename: filename,
+		Ext:      strings.TrimPrefix(filename, item.ID),
+	}
+	if data.Project == "" {
+		data.Project = filepath.Base(m.Config.Workspace.RootPath)
+	}
+
+	changed := false
+	for i, tmpl := range m.keyTemplates {
+		if tmpl == nil || item.Key(m.storeName(i)) != "" {
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return changed, fmt.Errorf("key template of %s: %v", m.storeName(i), err)
+		}
+		key := strings.TrimPrefix(path.Clean("/"+buf.String()), "/")
+		if key == "" {
+			return changed, fmt.Errorf("key template of %s: empty key", m.storeName(i))
+		}
+
+		item.SetKey(m.storeName(i), key)
+		changed = true
+	}
+	return changed, nil
+}
+
+// storeKey returns the key of item's archive in the i-th store.
+func (m *Metabox) storeKey(i int, item *tracker.Item) string {
+	if key := item.Key(m.storeName(i)); key != "" {
+		return key
+	}
+	return m.storedFilename(item)
+}
@@ -0,0 +1,128 @@
+package metabox
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nmcapule/metabox-go/config"
+	"github.com/nmcapule/metabox-go/storage"
+	"github.com/nmcapule/metabox-go/tracker"
+)
+
+func TestParseKeyTemplates(t *testing.T) {
+	tests := []struct {
+		name    string
+		backups []config.BackupConfig
+		wantErr bool
+	}{
+		{"None", []config.BackupConfig{{}, {}}, false},
+		{"Named", []config.BackupConfig{{}, {Name: "b", KeyTemplate: "{{.Filename}}"}}, false},
+		{"Unnamed", []config.BackupConfig{{Name: "a"}, {KeyTemplate: "{{.Filename}}"}}, true},
+		{"Invalid", []config.BackupConfig{{Name: "a", KeyTemplate: "{{.Filename"}}, true},
+	}
+	for _, tt := range tests {
+		templates, err := parseKeyTemplates(tt.backups)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: parseKeyTemplates() error = %v; want error %v", tt.name, err, tt.wantErr)
+		}
+		if err == nil && len(templates) != len(tt.backups) {
+			t.Errorf("%s: parseKeyTemplates() = %d templates; want %d", tt.name, len(templates), len(tt.backups))
+		}
+	}
+}
+
+func TestAssignKeys(t *testing.T) {
+	created := time.Date(2021, 3, 31, 23, 0, 0, 0, time.FixedZone("UTC-2", -2*60*60))
+	tests := []struct {
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{`{{.Project}}/{{.Author}}/{{.Created.Format "2006/01"}}/{{.Filename}}`, "proj/me/2021/04/abc.tar.gz", false},
+		{`{{index .Tags 0}}/{{.ID}}{{.Ext}}`, "nightly/abc.tar.gz", false},
+		{`../../{{.Filename}}`, "abc.tar.gz", false},
+		{`{{.Missing}}`, "", true},
+		{`/`, "", true},
+	}
+	for _, tt := range tests {
+		m := &Metabox{Config: &config.Config{}}
+		m.Config.Workspace.Project = "proj"
+		m.Config.Backups = []config.BackupConfig{{Name: "plain"}, {Name: "custom", KeyTemplate: tt.template}}
+		templates, err := parseKeyTemplates(m.Config.Backups)
+		if err != nil {
+			t.Fatalf("parseKeyTemplates(%q) error = %v", tt.template, err)
+		}
+		m.keyTemplates = templates
+
+		item := &tracker.Item{ID: "abc", Author: "me", Created: tracker.Time(created), Tags: []string{"nightly"}}
+		changed, err := m.assignKeys(item)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("assignKeys() with %q error = %v; want error %v", tt.template, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := item.Key("custom"); !changed || got != tt.want {
+			t.Errorf("assignKeys() with %q = %q, changed %v; want %q", tt.template, got, changed, tt.want)
+		}
+		if got := m.storeKey(0, item); got != "abc.tar.gz" {
+			t.Errorf("storeKey() of store without template = %q; want %q", got, "abc.tar.gz")
+		}
+
+		// Recorded keys are kept when the template changes.
+		m.Config.Backups[1].KeyTemplate = "other/{{.Filename}}"
+		m.keyTemplates, _ = parseKeyTemplates(m.Config.Backups)
+		if changed, err := m.assignKeys(item); err != nil || changed || item.Key("custom") != tt.want {
+			t.Errorf("assignKeys() again = %q, changed %v, %v; want %q kept", item.Key("custom"), changed, err, tt.want)
+		}
+	}
+}
+
+func TestRestoreWithKeyTemplate(t *testing.T) {
+	m := newTestMetabox(t)
+	target := m.derivedTargetPath()
+	writeTree(t, target, map[string]string{"a.txt": "a"})
+	item := backupTo(t, m, "keyed", archiveTar)
+	archive := readCached(t, m, m.storedFilename(item))
+
+	m.Config.Backups = []config.BackupConfig{{Name: "plain"}, {Name: "custom", KeyTemplate: "archives/{{.Filename}}"}}
+	templates, err := parseKeyTemplates(m.Config.Backups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.keyTemplates = templates
+	if _, err := m.assignKeys(item); err != nil {
+		t.Fatalf("assignKeys() error = %v", err)
+	}
+
+	// Only the custom store has the archive, under its own key.
+	custom := storage.NewMemory()
+	if err := custom.Upload("archives/"+m.storedFilename(item), bytes.NewReader(archive)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restore finds it after the backups are reordered and the template
+	// changed.
+	var log []string
+	m.Config.Backups = []config.BackupConfig{{Name: "custom", KeyTemplate: "{{.Filename}}"}, {Name: "plain", Priority: 1}}
+	m.Stores = []storage.Storage{
+		&testStore{Storage: custom, name: "custom", log: &log},
+		&testStore{Storage: storage.NewMemory(), name: "plain", log: &log},
+	}
+	if m.keyTemplates, err = parseKeyTemplates(m.Config.Backups); err != nil {
+		t.Fatal(err)
+	}
+
+	removeTree(t, m.derivedCachePath())
+	removeTree(t, target)
+	if err := m.StartRestore(item); err != nil {
+		t.Fatalf("StartRestore() error = %v (downloads %q)", err, log)
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(target, "a.txt")); err != nil || string(got) != "a" {
+		t.Errorf("restored a.txt = %q, %v; want %q", got, err, "a")
+	}
+}
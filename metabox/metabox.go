@@ -7,6 +7,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"text/template"
 	"time"
 
 	"github.com/nmcapule/metabox-go/config"
@@ -20,6 +22,9 @@ type Metabox struct {
 	DB     *tracker.SimpleFileDB
 	Stores []storage.Storage
 	logger log.Logger
+
+	// keyTemplates holds the parsed key template of each store, if any.
+	keyTemplates []*template.Template
 }
 
 // New creates a new Metabox instance.
@@ -33,28 +38,38 @@ func New(cfg *config.Config) (*Metabox, error) {
 	}
 	box.DB = db
 
-	// Instantiate storages.
+	// Instantiate storages. The global rate limit is shared by all stores.
+	var limiter *storage.Limiter
+	if rate := cfg.Workspace.Options.RateLimit; rate > 0 {
+		limiter = storage.NewLimiter(int64(rate), storage.SystemClock)
+	}
 	var stores []storage.Storage
 	for i := range cfg.Backups {
-		var store storage.Storage
-		var err error
-		switch cfg.Backups[i].Driver {
-		case storage.LocalDriver:
-			store, err = storage.NewLocal(&cfg.Backups[i].Local)
-		case storage.RemoteDriver:
-			store, err = storage.NewRemote(&cfg.Backups[i].Remote)
-		case storage.S3Driver:
-			store, err = storage.NewS3(&cfg.Backups[i].S3)
-		default:
-			return nil, fmt.Errorf("unknown storage driver: %q", cfg.Backups[i].Driver)
+		// Keep the progress of resumable uploads in the cache.
+		if cfg.Backups[i].Driver == config.BackupDriverS3 && cfg.Backups[i].S3.StateDir == "" {
+			cfg.Backups[i].S3.StateDir = filepath.Join(box.derivedCachePath(), "multipart")
 		}
+		store, err := storage.Open(&cfg.Backups[i])
 		if err != nil {
 			return nil, err
 		}
+		if limiter != nil {
+			store = storage.NewThrottle(store, limiter)
+		}
 		stores = append(stores, store)
 	}
 	box.Stores = stores
 
+	if box.keyTemplates, err = parseKeyTemplates(cfg.Backups); err != nil {
+		return nil, err
+	}
+	if _, err := box.archiveName(); err != nil {
+		return nil, err
+	}
+	if _, err := newCodec(box.codecName(), cfg.Workspace.Options.CompressLevel, cfg.Workspace.Options.CompressThreads); err != nil {
+		return nil, err
+	}
+
 	return box, nil
 }
 
@@ -109,21 +124,44 @@ func (m *Metabox) StartBackup() (*tracker.Item, error) {
 			item.Tags = append(item.Tags, tag)
 		}
 	} else {
-		if err := m.compress(filepaths, sum); err != nil {
+		item = &tracker.Item{
+			ID:      sum,
+			Created: tracker.Time(time.Now()),
+			Author:  m.Config.Workspace.UserIdentifier,
+			Tags:    m.Config.Workspace.TagsGenerator,
+		}
+		item.SetMeta(tracker.MetaHash, m.hashAlgorithm())
+		item.SetMeta(tracker.MetaHashVersion, hashVersion)
+		item.SetMeta(tracker.MetaFiles, strconv.Itoa(len(filepaths)))
+		archive, err := m.archiveName()
+		if err != nil {
 			return nil, err
 		}
+		item.SetMeta(tracker.MetaArchive, archive)
+		// Zip archives compress each entry themselves.
+		if archive == archiveTar {
+			item.SetMeta(tracker.MetaCompress, m.codecName())
+		}
 
-		// 3. upload to backups
-		if err := m.uploadToBackups(sum); err != nil {
+		if err := m.compress(filepaths, item); err != nil {
 			return nil, err
 		}
 
-		// 4. record to versioning file
-		item = &tracker.Item{
-			ID:      sum,
-			Created: tracker.Time(time.Now()),
-			Author:  m.Config.Workspace.UserIdentifier,
-			Tags:    m.Config.Workspace.TagsGenerator,
+		// Encrypt the archive if recipients are configured.
+		if recipients := m.Config.Workspace.Encryption.Recipients; len(recipients) > 0 {
+			if err := m.encrypt(item, recipients); err != nil {
+				return nil, err
+			}
+			item.SetRecipients(recipients)
+		}
+
+		if _, err := m.assignKeys(item); err != nil {
+			return nil, err
+		}
+
+		// 3. upload to backups
+		if err := m.uploadToBackups(item); err != nil {
+			return nil, err
 		}
 	}
 
@@ -157,15 +195,25 @@ func (m *Metabox) StartRestore(item *tracker.Item) error {
 	}
 
 	// 2. download from backups if does not exist in cache
-	cache := filepath.FromSlash(filepath.Join(m.derivedCachePath(), m.compressedFilename(item.ID)))
+	cache := filepath.FromSlash(filepath.Join(m.derivedCachePath(), m.compressedFilename(item)))
 	if _, err := os.Stat(cache); os.IsNotExist(err) {
-		if err := m.downloadFromBackups(item.ID); err != nil {
-			return err
+		stored := filepath.FromSlash(filepath.Join(m.derivedCachePath(), m.storedFilename(item)))
+		if _, err := os.Stat(stored); os.IsNotExist(err) {
+			if err := m.downloadFromBackups(item); err != nil {
+				return err
+			}
+		}
+
+		// Decrypt the archive if it was encrypted.
+		if len(item.Recipients()) > 0 {
+			if err := m.decrypt(item); err != nil {
+				return err
+			}
 		}
 	}
 
 	// 3. extract and copy to target path
-	if err := m.extract(item.ID); err != nil {
+	if err := m.extract(item); err != nil {
 		return err
 	}
 
@@ -240,15 +288,17 @@ func (m *Metabox) walk() ([]string, error) {
 	}
 
 	var filepaths []string
+	listed := make(map[string]bool)
 	fn := func(path string, info os.FileInfo, err error) error {
-		// Skip directories.
+		// Directories are listed when they are empty or hold a listed path,
+		// so that their metadata is kept. Symbolic links are kept as is.
 		if info.IsDir() {
-			return nil
-		}
-
-		// Skip symbolic links.
-		if info.Mode()&os.ModeSymlink == os.ModeSymlink {
-			return nil
+			if path == target {
+				return nil
+			}
+			if empty, err := isEmptyDir(path); err != nil || !empty {
+				return err
+			}
 		}
 
 		// If includes is specified, filter out non-matching paths.
@@ -278,8 +328,17 @@ func (m *Metabox) walk() ([]string, error) {
 			}
 		}
 
-		// Append to list of filepaths.
+		// Append to list of filepaths, after the directories leading to it.
+		var parents []string
+		for dir := filepath.Dir(path); dir != target && !listed[dir]; dir = filepath.Dir(dir) {
+			parents = append(parents, dir)
+			listed[dir] = true
+		}
+		for i := len(parents) - 1; i >= 0; i-- {
+			filepaths = append(filepaths, parents[i])
+		}
 		filepaths = append(filepaths, path)
+		listed[path] = true
 
 		return nil
 	}
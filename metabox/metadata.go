@@ -0,0 +1,102 @@
+package metabox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nmcapule/metabox-go/storage"
+	"github.com/nmcapule/metabox-go/tracker"
+)
+
+// metadataExt is appended to the key of an archive to name its sidecar
+// metadata object.
+const metadataExt = ".meta.json"
+
+// metadata describes a backup in a sidecar object next to its archive, so
+// that the tracker can be rebuilt from the contents of a store alone.
+type metadata struct {
+	ID      string            `json:"id"`
+	Archive string            `json:"archive"`
+	Created time.Time         `json:"created"`
+	Author  string            `json:"author"`
+	Tags    []string          `json:"tags"`
+	Hash    string            `json:"hash,omitempty"`
+	Size    int64             `json:"size"`
+	Files   int               `json:"files,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+// newMetadata describes item, whose archive is key and has size bytes.
+func newMetadata(item *tracker.Item, key string, size int64) *metadata {
+	md := &metadata{
+		ID:      item.ID,
+		Archive: key,
+		Created: time.Time(item.Created).UTC(),
+		Author:  item.Author,
+		Tags:    item.Tags,
+		Size:    size,
+		Hash:    item.Meta[tracker.MetaHash],
+	}
+	if md.Tags == nil {
+		md.Tags = []string{}
+	}
+	md.Files, _ = strconv.Atoi(item.Meta[tracker.MetaFiles])
+
+	// Remaining attributes are kept as is.
+	for k, v := range item.Meta {
+		if k == tracker.MetaHash || k == tracker.MetaFiles {
+			continue
+		}
+		if md.Meta == nil {
+			md.Meta = make(map[string]string)
+		}
+		md.Meta[k] = v
+	}
+	return md
+}
+
+// item returns the tracker item described by md.
+func (md *metadata) item() *tracker.Item {
+	item := &tracker.Item{
+		ID:      md.ID,
+		Created: tracker.Time(md.Created),
+		Author:  md.Author,
+		Tags:    md.Tags,
+	}
+	for k, v := range md.Meta {
+		item.SetMeta(k, v)
+	}
+	item.SetMeta(tracker.MetaHash, md.Hash)
+	if md.Files > 0 {
+		item.SetMeta(tracker.MetaFiles, strconv.Itoa(md.Files))
+	}
+	return item
+}
+
+// uploadMetadata writes the sidecar metadata object of an archive to store i.
+func (m *Metabox) uploadMetadata(i int, item *tracker.Item, key string, size int64) error {
+	b, err := json.MarshalIndent(newMetadata(item, key, size), "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding metadata of %s: %v", key, err)
+	}
+	return m.Stores[i].Upload(key+metadataExt, bytes.NewReader(append(b, '\n')))
+}
+
+// downloadMetadata reads the sidecar metadata object with key from store i.
+func (m *Metabox) downloadMetadata(i int, key string) (*metadata, error) {
+	var buf storage.Buffer
+	if err := m.Stores[i].Download(key, &buf); err != nil {
+		return nil, err
+	}
+	var md metadata
+	if err := json.Unmarshal(buf.Bytes(), &md); err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", key, err)
+	}
+	if md.ID == "" {
+		return nil, fmt.Errorf("decoding %s: missing id", key)
+	}
+	return &md, nil
+}
@@ -0,0 +1,111 @@
+package metabox
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/nmcapule/metabox-go/storage"
+)
+
+// RebuildOptions configures Metabox.RebuildTracker.
+type RebuildOptions struct {
+	// Replace discards the current tracker entries instead of merging.
+	Replace bool
+	// DryRun only reports the entries that would be added.
+	DryRun bool
+	// Stores limits the rebuild to the stores with these names. Empty means all.
+	Stores []string
+}
+
+// RebuildTracker recreates tracker entries from the sidecar metadata objects
+// found in the stores. Entries already in the tracker are kept, gaining the
+// tags recorded in the stores.
+func (m *Metabox) RebuildTracker(opts RebuildOptions) error {
+	sources, err := m.storesNamed(opts.Stores)
+	if err != nil {
+		return err
+	}
+
+	// Changes are only made in memory until the tracker is flushed.
+	if opts.Replace {
+		items, err := m.DB.Query()
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			m.DB.Delete(item.ID)
+		}
+	}
+
+	var errs storeErrors
+	var added, updated int
+	for _, i := range m.storesByPriority() {
+		if !sources[i] {
+			continue
+		}
+
+		keys, err := m.Stores[i].List("")
+		if errors.Is(err, storage.ErrUnsupported) {
+			log.Printf("rebuild: skipping %s: cannot list items", m.storeName(i))
+			continue
+		}
+		if err != nil {
+			errs = append(errs, storeError{store: m.storeName(i), err: err})
+			continue
+		}
+
+		for _, key := range keys {
+			if !strings.HasSuffix(key, metadataExt) {
+				continue
+			}
+			md, err := m.downloadMetadata(i, key)
+			if err != nil {
+				errs = append(errs, storeError{store: m.storeName(i), err: err})
+				continue
+			}
+
+			item, err := m.DB.Get(md.ID)
+			if err != nil || item == nil {
+				log.Printf("rebuild: add %s (from %s)", md.ID, m.storeName(i))
+				item = md.item()
+				m.DB.Put(md.ID, item)
+				added++
+			}
+
+			// Remember where the archive is if it is not at the default key.
+			if md.Archive != m.storedFilename(item) && item.Key(m.storeName(i)) != md.Archive {
+				item.SetKey(m.storeName(i), md.Archive)
+				updated++
+			}
+
+			// Merge the tags of copies of the same backup.
+			changed := false
+			for _, tag := range md.Tags {
+				if !item.Tags.Has(tag) {
+					item.Tags = append(item.Tags, tag)
+					changed = true
+				}
+			}
+			if changed {
+				log.Printf("rebuild: update tags of %s (from %s)", md.ID, m.storeName(i))
+				updated++
+			}
+		}
+	}
+
+	if opts.DryRun {
+		log.Printf("rebuild: would add %d and update %d entries", added, updated)
+	} else {
+		log.Printf("rebuild: added %d and updated %d entries", added, updated)
+		if err := m.DB.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("rebuild: %v", errs)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package metabox
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/nmcapule/metabox-go/config"
+	"github.com/nmcapule/metabox-go/storage"
+	"github.com/nmcapule/metabox-go/tracker"
+)
+
+func TestRebuildTracker(t *testing.T) {
+	m := newTestMetabox(t)
+	newTestDB(t, m)
+	m.Config.Workspace.UserIdentifier = "me"
+	m.Config.Backups = []config.BackupConfig{{Name: "a"}, {Name: "b", KeyTemplate: "archives/{{.Filename}}"}}
+	m.Stores = []storage.Storage{storage.NewMemory(), storage.NewMemory()}
+	templates, err := parseKeyTemplates(m.Config.Backups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.keyTemplates = templates
+
+	// Make two backups with different tags.
+	for i, tags := range [][]string{{"nightly"}, {"release", "env:prod"}} {
+		writeTree(t, m.derivedTargetPath(), map[string]string{"a.txt": string(rune('a' + i))})
+		m.Config.Workspace.TagsGenerator = tags
+		if _, err := m.StartBackup(); err != nil {
+			t.Fatalf("StartBackup() error = %v", err)
+		}
+	}
+	want := readTracker(t, m)
+	if len(want) != 2 {
+		t.Fatalf("tracker has %d entries after two backups; want 2", len(want))
+	}
+
+	// Lose the tracker and rebuild it from the store with custom keys.
+	if err := os.Remove(m.derivedVersionsPath()); err != nil {
+		t.Fatal(err)
+	}
+	newTestDB(t, m)
+	if err := m.RebuildTracker(RebuildOptions{Stores: []string{"b"}}); err != nil {
+		t.Fatalf("RebuildTracker() error = %v", err)
+	}
+	got := readTracker(t, m)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rebuilt tracker:")
+		for _, item := range got {
+			t.Errorf("  %+v", *item)
+		}
+		t.Errorf("want:")
+		for _, item := range want {
+			t.Errorf("  %+v", *item)
+		}
+	}
+
+	// Rebuilding again only merges tags that were added since.
+	item := got[0]
+	item.Tags = tracker.Tags{}
+	m.DB.Put(item.ID, item)
+	if err := m.RebuildTracker(RebuildOptions{}); err != nil {
+		t.Fatalf("second RebuildTracker() error = %v", err)
+	}
+	if got := readTracker(t, m); !reflect.DeepEqual(got, want) {
+		t.Errorf("tracker after second rebuild differs, first entry has tags %q; want %q", got[0].Tags, want[0].Tags)
+	}
+}
+
+// readTracker reads the tracker of m back from its file, sorted by ID.
+func readTracker(t *testing.T, m *Metabox) []*tracker.Item {
+	db, err := tracker.NewSimpleFileDB(m.derivedVersionsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := db.Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items
+}
@@ -0,0 +1,139 @@
+package metabox
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// SyncOptions configures Metabox.Sync.
+type SyncOptions struct {
+	// DryRun only reports the archives that would be copied.
+	DryRun bool
+	// Stores limits syncing to the stores with these names. Empty means all.
+	Stores []string
+}
+
+// Sync copies every tracked archive that is missing from a store, using the
+// workspace cache or another store that has it as the source.
+func (m *Metabox) Sync(opts SyncOptions) error {
+	targets, err := m.storesNamed(opts.Stores)
+	if err != nil {
+		return err
+	}
+
+	items, err := m.DB.Query()
+	if err != nil {
+		return err
+	}
+
+	var errs storeErrors
+	var assigned bool
+	for _, item := range items {
+		key := m.storedFilename(item)
+
+		// Stores added since the backup was made need keys for it.
+		changed, err := m.assignKeys(item)
+		if err != nil {
+			return err
+		}
+		assigned = assigned || changed
+
+		// Find which stores have the archive and which lack it.
+		var sources []int
+		missing := make(map[int]bool)
+		for _, i := range m.storesByPriority() {
+			exists, err := m.Stores[i].Exists(m.storeKey(i, item))
+			if err != nil {
+				log.Printf("sync: exists %s in %s: %v", m.storeKey(i, item), m.storeName(i), err)
+				if targets[i] {
+					errs = append(errs, storeError{store: m.storeName(i), err: err})
+				}
+				continue
+			}
+			if exists {
+				sources = append(sources, i)
+			} else if targets[i] {
+				missing[i] = true
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		cache := filepath.Join(m.derivedCachePath(), key)
+		_, err = os.Stat(cache)
+		cached := err == nil
+
+		if opts.DryRun {
+			source := "cache"
+			if !cached && len(sources) > 0 {
+				source = m.storeName(sources[0])
+			} else if !cached {
+				source = "nowhere"
+			}
+			for i := range missing {
+				log.Printf("sync: would copy %s to %s (from %s)", key, m.storeName(i), source)
+			}
+			continue
+		}
+
+		// Make sure the archive is in the cache before copying it around.
+		if !cached {
+			if err := ensurePathExists(m.derivedCachePath()); err != nil {
+				return err
+			}
+			if err := m.downloadFromStores(item, sources); err != nil {
+				for i := range missing {
+					errs = append(errs, storeError{store: m.storeName(i), err: fmt.Errorf("%s: no source: %v", key, err)})
+				}
+				continue
+			}
+		}
+
+		for i := range missing {
+			if err := m.uploadToStore(i, item); err != nil {
+				errs = append(errs, storeError{store: m.storeName(i), err: err})
+			}
+		}
+	}
+
+	// Record the keys of archives in new stores.
+	if assigned && !opts.DryRun {
+		if err := m.DB.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("sync: %v", errs)
+	}
+	return nil
+}
+
+// storesNamed returns the set of indices of stores with the given names, or
+// of all stores if names is empty.
+func (m *Metabox) storesNamed(names []string) (map[int]bool, error) {
+	indices := make(map[int]bool)
+	if len(names) == 0 {
+		for i := range m.Stores {
+			indices[i] = true
+		}
+		return indices, nil
+	}
+
+	for _, name := range names {
+		found := false
+		for i := range m.Stores {
+			if m.storeName(i) == name {
+				indices[i] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown store: %q", name)
+		}
+	}
+	return indices, nil
+}
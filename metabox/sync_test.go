@@ -0,0 +1,58 @@
+package metabox
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nmcapule/metabox-go/config"
+	"github.com/nmcapule/metabox-go/storage"
+)
+
+func TestSync(t *testing.T) {
+	m := newTestMetabox(t)
+	newTestDB(t, m)
+	writeTree(t, m.derivedTargetPath(), map[string]string{"a.txt": "a"})
+	item := backupTo(t, m, "synced", archiveTar)
+	m.DB.Put(item.ID, item)
+
+	// Only a has the archive, and it is no longer cached.
+	var log []string
+	m.Config.Backups = []config.BackupConfig{{Name: "a"}, {Name: "b"}}
+	m.Stores = []storage.Storage{
+		&testStore{Storage: storage.NewMemory(), name: "a", log: &log},
+		&testStore{Storage: storage.NewMemory(), name: "b", log: &log},
+	}
+	if err := m.uploadToStore(0, item); err != nil {
+		t.Fatal(err)
+	}
+	removeTree(t, m.derivedCachePath())
+	log = nil
+
+	if err := m.Sync(SyncOptions{DryRun: true}); err != nil {
+		t.Fatalf("Sync() dry run error = %v", err)
+	}
+	if len(log) != 0 {
+		t.Errorf("Sync() dry run made calls %q; want none", log)
+	}
+
+	if err := m.Sync(SyncOptions{}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	// The archive is uploaded along with its sidecar.
+	if want := []string{"download a", "upload b", "upload b"}; !reflect.DeepEqual(log, want) {
+		t.Errorf("Sync() made calls %q; want %q", log, want)
+	}
+	key := m.storeKey(1, item)
+	if keys, err := m.Stores[1].List(""); err != nil || !reflect.DeepEqual(keys, []string{key, key + metadataExt}) {
+		t.Errorf("b has %q, %v after Sync(); want %q", keys, err, []string{key, key + metadataExt})
+	}
+
+	// Everything is in sync now.
+	log = nil
+	if err := m.Sync(SyncOptions{}); err != nil {
+		t.Fatalf("second Sync() error = %v", err)
+	}
+	if len(log) != 0 {
+		t.Errorf("second Sync() made calls %q; want none", log)
+	}
+}
@@ -2,6 +2,7 @@ package metabox
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -26,3 +27,19 @@ func ensurePathExists(path string) error {
 	}
 	return err
 }
+
+// isEmptyDir reports whether the directory at path has no entries.
+func isEmptyDir(path string) (bool, error) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer dir.Close()
+
+	if _, err := dir.Readdirnames(1); err == io.EOF {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("reading %q: %v", path, err)
+	}
+	return false, nil
+}
@@ -0,0 +1,44 @@
+package storage
+
+import "sync"
+
+// Buffer is an in-memory WriterWriterAt for small items. Drivers may call
+// WriteAt concurrently.
+type Buffer struct {
+	mu  sync.Mutex
+	buf []byte
+	off int64
+}
+
+// Write appends p at the current offset.
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := b.writeAt(p, b.off)
+	b.off += int64(n)
+	return n, nil
+}
+
+// WriteAt writes p at offset off, growing the buffer as needed.
+func (b *Buffer) WriteAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.writeAt(p, off), nil
+}
+
+func (b *Buffer) writeAt(p []byte, off int64) int {
+	if end := off + int64(len(p)); end > int64(len(b.buf)) {
+		b.buf = append(b.buf, make([]byte, end-int64(len(b.buf)))...)
+	}
+	return copy(b.buf[off:], p)
+}
+
+// Bytes returns the contents of the buffer.
+func (b *Buffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf
+}
@@ -1,7 +1,54 @@
 package storage
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Error kinds returned by storage operations. Use errors.Is to test for them.
+var (
+	ErrNotExist    = errors.New("item does not exist")
+	ErrPermission  = errors.New("permission denied")
+	ErrUnavailable = errors.New("storage unavailable")
+	ErrUnsupported = errors.New("operation not supported by storage")
+)
 
 var (
 	errUnimplemented = errors.New("not yet implemented")
 )
+
+// Error records a failed storage operation along with its error kind.
+type Error struct {
+	Op   string
+	Key  string
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %q: %v", e.Op, e.Key, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the error kind of e.
+func (e *Error) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// httpError classifies a non-successful HTTP response into a storage *Error.
+func httpError(op, key string, resp *http.Response) error {
+	var kind error
+	switch code := resp.StatusCode; {
+	case code == http.StatusNotFound:
+		kind = ErrNotExist
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		kind = ErrPermission
+	case code >= http.StatusInternalServerError:
+		kind = ErrUnavailable
+	}
+	return &Error{Op: op, Key: key, Kind: kind, Err: fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status)}
+}
@@ -0,0 +1,289 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nmcapule/metabox-go/config"
+)
+
+// ExecHelperPrefix is prepended to the configured name to find the helper
+// binary in $PATH, e.g. `name: foo` runs `metabox-storage-foo`.
+const ExecHelperPrefix = "metabox-storage-"
+
+// Exec implements a storage backed by an external helper process, similar to
+// git's remote helpers. The helper is started once with the configured args
+// and receives one request line at a time on stdin:
+//
+//	exists <key>          -> "yes" | "no"
+//	upload <key> <size>   -> "ok"   (request line is followed by <size> bytes)
+//	download <key>        -> "ok <size>" followed by <size> bytes | "missing"
+//	list <prefix>         -> one "<key>" per line, terminated by an empty line
+//	                         (never "missing", which is a valid key)
+//	delete <key>          -> "ok"
+//	stat <key>            -> "ok <size> <unix-mtime> <checksum|->" | "missing"
+//
+// Keys and prefixes are percent-encoded as URL path segments. Any request may
+// instead be answered with "error <message>". The helper's stderr is passed
+// through to metabox's stderr. See ServeHelper for a Go implementation of the
+// helper side.
+type Exec struct {
+	config *config.ExecStorageConfig
+	path   string
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func init() {
+	Register(ExecDriver, func(cfg *config.BackupConfig) (Storage, error) {
+		return NewExec(&cfg.Exec)
+	})
+}
+
+// NewExec creates an Exec storage from config.
+//
+// The helper is not started until the first storage operation.
+func NewExec(config *config.ExecStorageConfig) (*Exec, error) {
+	if config.Name == "" {
+		return nil, fmt.Errorf("exec: name is required")
+	}
+	path, err := exec.LookPath(ExecHelperPrefix + config.Name)
+	if err != nil {
+		return nil, fmt.Errorf("exec: %v", err)
+	}
+	return &Exec{
+		config: config,
+		path:   path,
+	}, nil
+}
+
+// start runs the helper process if it is not yet running. Must be called with
+// s.mu held.
+func (s *Exec) start() error {
+	if s.cmd != nil {
+		return nil
+	}
+
+	cmd := exec.Command(s.path, s.config.Args...)
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	for k, v := range s.config.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("retrieving helper pipe: %v", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("retrieving helper pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting helper %q: %v", s.path, err)
+	}
+
+	s.cmd = cmd
+	s.stdin = stdin
+	s.stdout = bufio.NewReader(stdout)
+	return nil
+}
+
+// stop terminates the helper process. Must be called with s.mu held.
+func (s *Exec) stop() error {
+	if s.cmd == nil {
+		return nil
+	}
+	s.stdin.Close()
+	err := s.cmd.Wait()
+	s.cmd, s.stdin, s.stdout = nil, nil, nil
+	return err
+}
+
+// Close stops the helper process, if running.
+func (s *Exec) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stop()
+}
+
+// roundtrip sends a request line, plus an optional body of size bytes, and
+// calls handle with the helper's reply line. Must be called with s.mu held.
+func (s *Exec) roundtrip(op, key string, body io.Reader, size int64, handle func(reply string) error) error {
+	if err := s.start(); err != nil {
+		return &Error{Op: op, Key: key, Kind: ErrUnavailable, Err: err}
+	}
+
+	fail := func(err error) error {
+		// The stream is out of sync, so the helper can't be reused.
+		s.stop()
+		return &Error{Op: op, Key: key, Kind: ErrUnavailable, Err: err}
+	}
+
+	line := op + " " + url.PathEscape(key)
+	if body != nil {
+		line += " " + strconv.FormatInt(size, 10)
+	}
+	if _, err := io.WriteString(s.stdin, line+"\n"); err != nil {
+		return fail(fmt.Errorf("write request: %v", err))
+	}
+	if body != nil {
+		if n, err := io.Copy(s.stdin, body); err != nil {
+			return fail(fmt.Errorf("write body: %v", err))
+		} else if n != size {
+			return fail(fmt.Errorf("write body: wrote %d of %d bytes", n, size))
+		}
+	}
+
+	reply, err := s.stdout.ReadString('\n')
+	if err != nil {
+		return fail(fmt.Errorf("read reply: %v", err))
+	}
+	reply = strings.TrimSuffix(reply, "\n")
+
+	switch {
+	case reply == "missing" && op != "list":
+		return &Error{Op: op, Key: key, Kind: ErrNotExist, Err: ErrNotExist}
+	case strings.HasPrefix(reply, "error "):
+		return &Error{Op: op, Key: key, Err: fmt.Errorf("helper: %s", strings.TrimPrefix(reply, "error "))}
+	}
+	if err := handle(reply); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+func expectOK(reply string) error {
+	if reply != "ok" {
+		return fmt.Errorf("unexpected reply %q", reply)
+	}
+	return nil
+}
+
+func (s *Exec) Exists(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var exists bool
+	err := s.roundtrip("exists", key, nil, 0, func(reply string) error {
+		switch reply {
+		case "yes":
+			exists = true
+		case "no":
+			exists = false
+		default:
+			return fmt.Errorf("unexpected reply %q", reply)
+		}
+		return nil
+	})
+	return exists, err
+}
+
+func (s *Exec) Upload(key string, source io.Reader) error {
+	// The protocol needs the size up front, so spool unsized sources first.
+	size, ok := readerSize(source)
+	if !ok {
+		tmp, err := ioutil.TempFile("", ExecHelperPrefix+"*")
+		if err != nil {
+			return fmt.Errorf("create spool file: %v", err)
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		if size, err = io.Copy(tmp, source); err != nil {
+			return fmt.Errorf("spool %q: %v", key, err)
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("spool %q: %v", key, err)
+		}
+		source = tmp
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.roundtrip("upload", key, source, size, expectOK)
+}
+
+func (s *Exec) Download(key string, destination WriterWriterAt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.roundtrip("download", key, nil, 0, func(reply string) error {
+		var size int64
+		if _, err := fmt.Sscanf(reply, "ok %d", &size); err != nil {
+			return fmt.Errorf("unexpected reply %q", reply)
+		}
+		if _, err := io.CopyN(destination, s.stdout, size); err != nil {
+			return fmt.Errorf("read body: %v", err)
+		}
+		return nil
+	})
+}
+
+func (s *Exec) List(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	err := s.roundtrip("list", prefix, nil, 0, func(reply string) error {
+		for line := reply; line != ""; {
+			key, err := url.PathUnescape(line)
+			if err != nil {
+				return fmt.Errorf("unescape %q: %v", line, err)
+			}
+			keys = append(keys, key)
+
+			if line, err = s.stdout.ReadString('\n'); err != nil {
+				return fmt.Errorf("read reply: %v", err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func (s *Exec) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.roundtrip("delete", key, nil, 0, expectOK)
+}
+
+func (s *Exec) Stat(key string) (*ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var info *ObjectInfo
+	err := s.roundtrip("stat", key, nil, 0, func(reply string) error {
+		var size, mtime int64
+		var checksum string
+		if _, err := fmt.Sscanf(reply, "ok %d %d %s", &size, &mtime, &checksum); err != nil {
+			return fmt.Errorf("unexpected reply %q", reply)
+		}
+		if checksum == "-" {
+			checksum = ""
+		}
+		info = &ObjectInfo{
+			Key:      key,
+			Size:     size,
+			ModTime:  time.Unix(mtime, 0),
+			Checksum: checksum,
+		}
+		return nil
+	})
+	return info, err
+}
@@ -0,0 +1,152 @@
+package storage_test
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/nmcapule/metabox-go/config"
+	"github.com/nmcapule/metabox-go/internal/testenv"
+	"github.com/nmcapule/metabox-go/storage"
+	"github.com/nmcapule/metabox-go/storage/storagetest"
+)
+
+// execHelperEnv makes the test binary act as a metabox-storage-test helper
+// that stores items in the directory given as its first argument, like
+// cmd/metabox-storage-local. A "-" directory makes it exit right away.
+const execHelperEnv = "METABOX_TEST_EXEC_HELPER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(execHelperEnv) != "" {
+		log.SetPrefix("metabox-storage-test: ")
+		if len(os.Args) != 2 || os.Args[1] == "-" {
+			os.Exit(1)
+		}
+		store, err := storage.NewLocal(&config.LocalStorageConfig{Path: os.Args[1]})
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if err := storage.ServeHelper(store, os.Stdin, os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestExecKeys(t *testing.T) {
+	s := newExec(t, tempDir(t))
+
+	// Keys that look like protocol replies must round-trip as keys.
+	keys := []string{"error boom", "missing", "no", "ok 3", "sub/dir name/100%.tar.gz", "yes"}
+	for _, key := range keys {
+		if err := s.Upload(key, bytes.NewReader([]byte(key))); err != nil {
+			t.Fatalf("Upload(%q) error = %v", key, err)
+		}
+	}
+
+	got, err := s.List("")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, keys) {
+		t.Errorf("List() = %q; want %q", got, keys)
+	}
+	if got, err := s.List("missing"); err != nil || !reflect.DeepEqual(got, []string{"missing"}) {
+		t.Errorf("List(%q) = %q, %v; want [missing], nil", "missing", got, err)
+	}
+
+	for _, key := range keys {
+		var buf storagetest.Buffer
+		if err := s.Download(key, &buf); err != nil || string(buf.Bytes()) != key {
+			t.Errorf("Download(%q) = %q, %v; want %q", key, buf.Bytes(), err, key)
+		}
+		if info, err := s.Stat(key); err != nil || info.Size != int64(len(key)) {
+			t.Errorf("Stat(%q) = %+v, %v; want size %d", key, info, err, len(key))
+		}
+	}
+}
+
+func TestExecMissing(t *testing.T) {
+	s := newExec(t, tempDir(t))
+
+	if exists, err := s.Exists("missing"); err != nil || exists {
+		t.Errorf("Exists() = %v, %v; want false, nil", exists, err)
+	}
+	if err := s.Download("missing", &storagetest.Buffer{}); !errors.Is(err, storage.ErrNotExist) {
+		t.Errorf("Download() error = %v; want ErrNotExist", err)
+	}
+	if _, err := s.Stat("missing"); !errors.Is(err, storage.ErrNotExist) {
+		t.Errorf("Stat() error = %v; want ErrNotExist", err)
+	}
+	if got, err := s.List("missing"); err != nil || len(got) != 0 {
+		t.Errorf("List() = %q, %v; want none", got, err)
+	}
+
+	// A failed lookup must leave the stream in sync for the next request.
+	if err := s.Upload("a.tar.gz", bytes.NewReader([]byte("a"))); err != nil {
+		t.Errorf("Upload() after missing key error = %v", err)
+	}
+}
+
+func TestExecRestart(t *testing.T) {
+	dir := tempDir(t)
+	s := newExec(t, dir)
+
+	if err := s.Upload("a.tar.gz", bytes.NewReader([]byte("a"))); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if exists, err := s.Exists("a.tar.gz"); err != nil || !exists {
+		t.Errorf("Exists() after Close() = %v, %v; want true, nil", exists, err)
+	}
+}
+
+func TestExecHelperExits(t *testing.T) {
+	s := newExec(t, "-")
+	if _, err := s.Exists("a.tar.gz"); !errors.Is(err, storage.ErrUnavailable) {
+		t.Errorf("Exists() error = %v; want ErrUnavailable", err)
+	}
+}
+
+func TestExecNotFound(t *testing.T) {
+	if _, err := storage.NewExec(&config.ExecStorageConfig{Name: "does-not-exist"}); err == nil {
+		t.Errorf("NewExec() with unknown helper = nil; want error")
+	}
+	if _, err := storage.NewExec(&config.ExecStorageConfig{}); err == nil {
+		t.Errorf("NewExec() without name = nil; want error")
+	}
+}
+
+// newExec creates an Exec storage that runs the test binary as its helper on
+// dir.
+func newExec(t *testing.T, dir string) *storage.Exec {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := tempDir(t)
+	if err := os.Symlink(exe, filepath.Join(bin, storage.ExecHelperPrefix+"test")); err != nil {
+		t.Fatal(err)
+	}
+	testenv.Setenv(t, "PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	s, err := storage.NewExec(&config.ExecStorageConfig{
+		Name: "test",
+		Args: []string{dir},
+		Env:  map[string]string{execHelperEnv: "1"},
+	})
+	if err != nil {
+		t.Fatalf("NewExec() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
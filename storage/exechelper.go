@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ServeHelper implements the helper side of the Exec storage protocol on top
+// of store. It reads requests from in and writes replies to out until in is
+// closed. Use it to write a `metabox-storage-<name>` helper in Go.
+func ServeHelper(store Storage, in io.Reader, out io.Writer) error {
+	r := bufio.NewReader(in)
+	w := bufio.NewWriter(out)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read request: %v", err)
+		}
+
+		fields := strings.Split(strings.TrimSuffix(line, "\n"), " ")
+		if len(fields) < 2 {
+			return fmt.Errorf("malformed request %q", line)
+		}
+		key, err := url.PathUnescape(fields[1])
+		if err != nil {
+			return fmt.Errorf("malformed key in request %q: %v", line, err)
+		}
+
+		switch op := fields[0]; op {
+		case "exists":
+			exists, err := store.Exists(key)
+			switch {
+			case err != nil:
+				replyError(w, err)
+			case exists:
+				fmt.Fprintln(w, "yes")
+			default:
+				fmt.Fprintln(w, "no")
+			}
+
+		case "upload":
+			if len(fields) != 3 {
+				return fmt.Errorf("malformed request %q", line)
+			}
+			size, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				return fmt.Errorf("malformed size in request %q: %v", line, err)
+			}
+			body := io.LimitReader(r, size)
+			if err := store.Upload(key, body); err != nil {
+				replyError(w, err)
+			} else {
+				fmt.Fprintln(w, "ok")
+			}
+			// Skip whatever the store did not consume to stay in sync.
+			if _, err := io.Copy(ioutil.Discard, body); err != nil {
+				return fmt.Errorf("read body: %v", err)
+			}
+
+		case "download":
+			if err := serveDownload(store, key, w); err != nil {
+				return err
+			}
+
+		case "list":
+			keys, err := store.List(key)
+			if errors.Is(err, ErrNotExist) {
+				// "missing" would be read as a key, so list nothing instead.
+				err = nil
+			}
+			if err != nil {
+				replyError(w, err)
+				break
+			}
+			for _, k := range keys {
+				fmt.Fprintln(w, url.PathEscape(k))
+			}
+			fmt.Fprintln(w)
+
+		case "delete":
+			if err := store.Delete(key); err != nil {
+				replyError(w, err)
+			} else {
+				fmt.Fprintln(w, "ok")
+			}
+
+		case "stat":
+			info, err := store.Stat(key)
+			if err != nil {
+				replyError(w, err)
+				break
+			}
+			checksum := info.Checksum
+			if checksum == "" {
+				checksum = "-"
+			}
+			fmt.Fprintf(w, "ok %d %d %s\n", info.Size, info.ModTime.Unix(), checksum)
+
+		default:
+			fmt.Fprintf(w, "error unknown command %q\n", op)
+		}
+
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("write reply: %v", err)
+		}
+	}
+}
+
+// serveDownload replies with the contents of key name. The contents are
+// spooled first since the reply must state the size up front.
+func serveDownload(store Storage, key string, w *bufio.Writer) error {
+	tmp, err := ioutil.TempFile("", ExecHelperPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("create spool file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := store.Download(key, tmp); err != nil {
+		replyError(w, err)
+		return nil
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		return fmt.Errorf("spool %q: %v", key, err)
+	}
+	size := info.Size()
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("spool %q: %v", key, err)
+	}
+
+	fmt.Fprintf(w, "ok %d\n", size)
+	if _, err := io.CopyN(w, tmp, size); err != nil {
+		return fmt.Errorf("write body: %v", err)
+	}
+	return nil
+}
+
+// replyError writes an error reply, mapping ErrNotExist to "missing".
+func replyError(w io.Writer, err error) {
+	if errors.Is(err, ErrNotExist) {
+		fmt.Fprintln(w, "missing")
+		return
+	}
+	msg := strings.Replace(err.Error(), "\n", " ", -1)
+	fmt.Fprintf(w, "error %s\n", msg)
+}
@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/nmcapule/metabox-go/config"
+)
+
+// HTTP implements a storage on a plain HTTP server that accepts PUT, GET, HEAD
+// and DELETE requests. Plain HTTP has no way to enumerate items, so List is
+// not supported.
+type HTTP struct {
+	config *config.HTTPStorageConfig
+	base   *url.URL
+	header http.Header
+	client *http.Client
+}
+
+func init() {
+	Register(HTTPDriver, func(cfg *config.BackupConfig) (Storage, error) {
+		return NewHTTP(&cfg.HTTP)
+	})
+}
+
+// NewHTTP creates an HTTP storage from config.
+func NewHTTP(config *config.HTTPStorageConfig) (*HTTP, error) {
+	base, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parse http url: %v", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("http url %q: scheme must be http or https", config.URL)
+	}
+
+	// Environment variables, e.g. tokens kept out of the config file, are
+	// already expanded when the config file is loaded.
+	header := make(http.Header)
+	for k, v := range config.Headers {
+		header.Set(k, v)
+	}
+
+	client := http.DefaultClient
+	if config.CAFile != "" {
+		pem, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading ca file: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %q", config.CAFile)
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client = &http.Client{Transport: transport}
+	}
+
+	return &HTTP{
+		config: config,
+		base:   base,
+		header: header,
+		client: client,
+	}, nil
+}
+
+// url returns the URL of key name.
+func (s *HTTP) url(key string) string {
+	u := *s.base
+	u.Path = path.Join("/", u.Path, key)
+	return u.String()
+}
+
+// do sends a request for key name with the configured headers attached.
+func (s *HTTP) do(op, key, method string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.url(key), body)
+	if err != nil {
+		return nil, &Error{Op: op, Key: key, Err: err}
+	}
+	for k, v := range s.header {
+		req.Header[k] = v
+	}
+	if size, ok := readerSize(body); ok {
+		req.ContentLength = size
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, &Error{Op: op, Key: key, Kind: ErrUnavailable, Err: err}
+	}
+	return resp, nil
+}
+
+func (s *HTTP) Exists(key string) (bool, error) {
+	resp, err := s.do("exists", key, http.MethodHead, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, httpError("exists", key, resp)
+	}
+}
+
+func (s *HTTP) Upload(key string, source io.Reader) error {
+	resp, err := s.do("upload", key, http.MethodPut, source)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return httpError("upload", key, resp)
+	}
+	return nil
+}
+
+func (s *HTTP) Download(key string, destination WriterWriterAt) error {
+	resp, err := s.do("download", key, http.MethodGet, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return httpError("download", key, resp)
+	}
+	if _, err := io.Copy(destination, resp.Body); err != nil {
+		return &Error{Op: "download", Key: key, Kind: ErrUnavailable, Err: err}
+	}
+	return nil
+}
+
+func (s *HTTP) List(prefix string) ([]string, error) {
+	return nil, &Error{Op: "list", Key: prefix, Kind: ErrUnsupported, Err: ErrUnsupported}
+}
+
+func (s *HTTP) Delete(key string) error {
+	resp, err := s.do("delete", key, http.MethodDelete, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return httpError("delete", key, resp)
+	}
+	return nil
+}
+
+func (s *HTTP) Stat(key string) (*ObjectInfo, error) {
+	resp, err := s.do("stat", key, http.MethodHead, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpError("stat", key, resp)
+	}
+
+	info := &ObjectInfo{
+		Key:      key,
+		Size:     resp.ContentLength,
+		Checksum: strings.Trim(resp.Header.Get("ETag"), `"`),
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+	return info, nil
+}
@@ -0,0 +1,189 @@
+package storage_test
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nmcapule/metabox-go/config"
+	"github.com/nmcapule/metabox-go/internal/testenv"
+	"github.com/nmcapule/metabox-go/storage"
+	"github.com/nmcapule/metabox-go/storage/storagetest"
+)
+
+func TestHTTP(t *testing.T) {
+	srv := httptest.NewServer(newObjectServer("Bearer s3cret"))
+	t.Cleanup(srv.Close)
+
+	s, err := storage.NewHTTP(&config.HTTPStorageConfig{
+		URL:     srv.URL + "/backups",
+		Headers: map[string]string{"Authorization": "Bearer s3cret"},
+	})
+	if err != nil {
+		t.Fatalf("NewHTTP() error = %v", err)
+	}
+
+	want := []byte("hello world")
+	if err := s.Upload("a/b.tar.gz", bytes.NewReader(want)); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if exists, err := s.Exists("a/b.tar.gz"); err != nil || !exists {
+		t.Errorf("Exists() = %v, %v; want true, nil", exists, err)
+	}
+	var got storagetest.Buffer
+	if err := s.Download("a/b.tar.gz", &got); err != nil || !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("Download() = %q, %v; want %q", got.Bytes(), err, want)
+	}
+	info, err := s.Stat("a/b.tar.gz")
+	if err != nil || info.Size != int64(len(want)) || info.ModTime.IsZero() || info.Checksum == "" {
+		t.Errorf("Stat() = %+v, %v; want size %d with mtime and checksum", info, err, len(want))
+	}
+	if err := s.Delete("a/b.tar.gz"); err != nil {
+		t.Errorf("Delete() error = %v", err)
+	}
+
+	if exists, err := s.Exists("a/b.tar.gz"); err != nil || exists {
+		t.Errorf("Exists() after Delete() = %v, %v; want false, nil", exists, err)
+	}
+	if err := s.Download("a/b.tar.gz", &storagetest.Buffer{}); !errors.Is(err, storage.ErrNotExist) {
+		t.Errorf("Download() after Delete() error = %v; want ErrNotExist", err)
+	}
+	if _, err := s.Stat("a/b.tar.gz"); !errors.Is(err, storage.ErrNotExist) {
+		t.Errorf("Stat() after Delete() error = %v; want ErrNotExist", err)
+	}
+	if err := s.Delete("a/b.tar.gz"); err != nil {
+		t.Errorf("Delete() of missing key error = %v", err)
+	}
+	if _, err := s.List(""); !errors.Is(err, storage.ErrUnsupported) {
+		t.Errorf("List() error = %v; want ErrUnsupported", err)
+	}
+}
+
+func TestHTTPHeaderEnv(t *testing.T) {
+	// Tokens may contain "$", which must not be expanded a second time.
+	srv := httptest.NewServer(newObjectServer("Bearer s3$cret"))
+	t.Cleanup(srv.Close)
+
+	path := filepath.Join(tempDir(t), "metabox.yml")
+	yml := fmt.Sprintf("backups:\n  - driver: http\n    http:\n      url: %s\n      headers:\n        Authorization: Bearer ${METABOX_TEST_TOKEN}\n", srv.URL)
+	if err := ioutil.WriteFile(path, []byte(yml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		env     string
+		wantErr error
+	}{
+		{"s3$cret", nil},
+		{"s3", storage.ErrPermission},
+		{"", storage.ErrPermission},
+	}
+	for _, tt := range tests {
+		testenv.Setenv(t, "METABOX_TEST_TOKEN", tt.env)
+		cfg, err := config.FromFile(path)
+		if err != nil {
+			t.Fatalf("FromFile() error = %v", err)
+		}
+		s, err := storage.Open(&cfg.Backups[0])
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		if _, err := s.Exists("a.tar.gz"); !errors.Is(err, tt.wantErr) {
+			t.Errorf("Exists() with token %q error = %v; want %v", tt.env, err, tt.wantErr)
+		}
+	}
+}
+
+func TestHTTPCAFile(t *testing.T) {
+	srv := httptest.NewUnstartedServer(newObjectServer(""))
+	// The untrusted client below makes the handshake fail on purpose.
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	caFile := filepath.Join(tempDir(t), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	trusted, err := storage.NewHTTP(&config.HTTPStorageConfig{URL: srv.URL, CAFile: caFile})
+	if err != nil {
+		t.Fatalf("NewHTTP() error = %v", err)
+	}
+	if err := trusted.Upload("a.tar.gz", bytes.NewReader([]byte("a"))); err != nil {
+		t.Errorf("Upload() with ca_file error = %v", err)
+	}
+
+	untrusted, err := storage.NewHTTP(&config.HTTPStorageConfig{URL: srv.URL})
+	if err != nil {
+		t.Fatalf("NewHTTP() error = %v", err)
+	}
+	if err := untrusted.Upload("a.tar.gz", bytes.NewReader([]byte("a"))); !errors.Is(err, storage.ErrUnavailable) {
+		t.Errorf("Upload() without ca_file error = %v; want ErrUnavailable", err)
+	}
+
+	if _, err := storage.NewHTTP(&config.HTTPStorageConfig{URL: srv.URL, CAFile: filepath.Join(tempDir(t), "missing.pem")}); err == nil {
+		t.Errorf("NewHTTP() with missing ca_file = nil; want error")
+	}
+}
+
+// objectServer is an in-memory HTTP object server that accepts PUT, GET, HEAD
+// and DELETE requests with the wanted Authorization header, if any.
+type objectServer struct {
+	auth string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newObjectServer(auth string) *objectServer {
+	return &objectServer{auth: auth, objects: make(map[string][]byte)}
+}
+
+func (s *objectServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.auth != "" && r.Header.Get("Authorization") != s.auth {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.objects[r.URL.Path] = body
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet, http.MethodHead:
+		data, ok := s.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, len(data)))
+		http.ServeContent(w, r, r.URL.Path, time.Now(), bytes.NewReader(data))
+	case http.MethodDelete:
+		if _, ok := s.objects[r.URL.Path]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(s.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
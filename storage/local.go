@@ -1,19 +1,50 @@
 package storage
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/nmcapule/metabox-go/config"
 )
 
+const (
+	// checksumSuffix is appended to a key to name its sha256 sidecar file.
+	checksumSuffix = ".sha256"
+	// tmpInfix marks in-progress uploads so they can be skipped when listing.
+	tmpInfix = ".tmp-"
+)
+
 // Local implements a local storage.
+//
+// Uploads are written to a temporary file, synced and then renamed in place so
+// that an interrupted upload never leaves a partial item behind. Each item is
+// accompanied by a "<key>.sha256" sidecar that is verified on download.
 type Local struct {
 	config *config.LocalStorageConfig
 }
 
+// localLocks holds a *sync.RWMutex per item path, so that an item and its
+// sidecar are replaced together and never read halfway through.
+var localLocks sync.Map
+
+// lockPath returns the lock of the item at path.
+func lockPath(path string) *sync.RWMutex {
+	mu, _ := localLocks.LoadOrStore(path, new(sync.RWMutex))
+	return mu.(*sync.RWMutex)
+}
+
+func init() {
+	Register(LocalDriver, func(cfg *config.BackupConfig) (Storage, error) {
+		return NewLocal(&cfg.Local)
+	})
+}
+
 // NewLocal creates a Local storage from config.
 func NewLocal(config *config.LocalStorageConfig) (*Local, error) {
 	return &Local{
@@ -33,28 +64,166 @@ func (s *Local) Exists(key string) (bool, error) {
 
 func (s *Local) Upload(key string, source io.Reader) error {
 	path := filepath.Join(s.config.Path, key)
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, os.FileMode(0644))
-	if err != nil {
-		return fmt.Errorf("open %q: %v", path, err)
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, os.FileMode(0755)); err != nil {
+		return fmt.Errorf("mkdir %q: %v", dir, err)
 	}
-	defer file.Close()
 
-	if _, err := io.Copy(file, source); err != nil {
+	mu := lockPath(path)
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Drop the previous sidecar first so it never vouches for new contents.
+	if err := os.Remove(path + checksumSuffix); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove checksum of %q: %v", path, err)
+	}
+
+	hasher := sha256.New()
+	err := WriteFileAtomic(path, func(w *os.File) error {
+		_, err := io.Copy(io.MultiWriter(w, hasher), source)
+		return err
+	})
+	if err != nil {
 		return fmt.Errorf("upload to %q: %v", path, err)
 	}
+
+	// Write the sidecar last. An interruption before this point leaves a
+	// complete item without a sidecar, which is downloaded unverified.
+	sum := fmt.Sprintf("%x  %s\n", hasher.Sum(nil), filepath.Base(path))
+	err = WriteFileAtomic(path+checksumSuffix, func(w *os.File) error {
+		_, err := io.WriteString(w, sum)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("write checksum of %q: %v", path, err)
+	}
 	return nil
 }
 
 func (s *Local) Download(key string, destination WriterWriterAt) error {
 	path := filepath.Join(s.config.Path, key)
+	mu := lockPath(path)
+	mu.RLock()
+	defer mu.RUnlock()
+
 	file, err := os.Open(path)
-	if err != nil {
+	if os.IsNotExist(err) {
+		return fmt.Errorf("download %q: %w", key, ErrNotExist)
+	} else if err != nil {
 		return fmt.Errorf("open %q: %v", path, err)
 	}
 	defer file.Close()
 
-	if _, err := io.Copy(destination, file); err != nil {
+	expected, err := readChecksum(path + checksumSuffix)
+	if err != nil {
+		return err
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(destination, hasher), file); err != nil {
 		return fmt.Errorf("download from %q: %v", path, err)
 	}
+
+	if actual := fmt.Sprintf("%x", hasher.Sum(nil)); expected != "" && actual != expected {
+		return fmt.Errorf("download from %q: checksum mismatch: expected %s, got %s", path, expected, actual)
+	}
 	return nil
 }
+
+func (s *Local) List(prefix string) ([]string, error) {
+	root := filepath.Clean(s.config.Path)
+
+	// Only walk the deepest directory that can contain matching keys.
+	start := root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		start = filepath.Join(root, filepath.FromSlash(prefix[:i]))
+	}
+
+	var keys []string
+	fn := func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		// Skip checksum sidecars and in-progress uploads.
+		if strings.HasSuffix(path, checksumSuffix) || strings.Contains(info.Name(), tmpInfix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return fmt.Errorf("relpath of %s: %v", path, err)
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	}
+
+	if err := filepath.Walk(start, fn); err != nil {
+		return nil, fmt.Errorf("list %q: %v", prefix, err)
+	}
+	return keys, nil
+}
+
+func (s *Local) Delete(key string) error {
+	path := filepath.Join(s.config.Path, key)
+	mu := lockPath(path)
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, p := range []string{path, path + checksumSuffix} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove %q: %v", p, err)
+		}
+	}
+	return nil
+}
+
+func (s *Local) Stat(key string) (*ObjectInfo, error) {
+	path := filepath.Join(s.config.Path, key)
+	mu := lockPath(path)
+	mu.RLock()
+	defer mu.RUnlock()
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("stat %q: %w", key, ErrNotExist)
+	} else if err != nil {
+		return nil, fmt.Errorf("stat %q: %v", path, err)
+	}
+
+	checksum, err := readChecksum(path + checksumSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectInfo{
+		Key:      key,
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		Checksum: checksum,
+	}, nil
+}
+
+// readChecksum returns the hex digest recorded in a sha256 sidecar file, or an
+// empty string if there is no sidecar.
+func readChecksum(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("read checksum %q: %v", path, err)
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("read checksum %q: empty file", path)
+	}
+	return fields[0], nil
+}
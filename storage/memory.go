@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nmcapule/metabox-go/config"
+)
+
+// Memory implements a storage that keeps items in memory. It is mostly useful
+// for tests, since items are lost once the process exits.
+type Memory struct {
+	mu    sync.RWMutex
+	items map[string]memoryItem
+}
+
+type memoryItem struct {
+	data    []byte
+	modTime time.Time
+}
+
+func init() {
+	Register(MemoryDriver, func(cfg *config.BackupConfig) (Storage, error) {
+		return NewMemory(), nil
+	})
+}
+
+// NewMemory creates an empty Memory storage.
+func NewMemory() *Memory {
+	return &Memory{
+		items: make(map[string]memoryItem),
+	}
+}
+
+func (s *Memory) Exists(key string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.items[key]
+	return ok, nil
+}
+
+func (s *Memory) Upload(key string, source io.Reader) error {
+	// Read outside of the lock, since source may be slow.
+	data, err := ioutil.ReadAll(source)
+	if err != nil {
+		return fmt.Errorf("upload %q: %v", key, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items[key] = memoryItem{data: data, modTime: time.Now()}
+	return nil
+}
+
+func (s *Memory) Download(key string, destination WriterWriterAt) error {
+	s.mu.RLock()
+	item, ok := s.items[key]
+	s.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("download %q: %w", key, ErrNotExist)
+	}
+	// Items are never modified in place, so data can be read without the lock.
+	if _, err := io.Copy(destination, bytes.NewReader(item.data)); err != nil {
+		return fmt.Errorf("download %q: %v", key, err)
+	}
+	return nil
+}
+
+func (s *Memory) List(prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []string
+	for key := range s.items {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *Memory) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.items, key)
+	return nil
+}
+
+func (s *Memory) Stat(key string) (*ObjectInfo, error) {
+	s.mu.RLock()
+	item, ok := s.items[key]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("stat %q: %w", key, ErrNotExist)
+	}
+	return &ObjectInfo{
+		Key:      key,
+		Size:     int64(len(item.data)),
+		ModTime:  item.modTime,
+		Checksum: fmt.Sprintf("%x", md5.Sum(item.data)),
+	}, nil
+}
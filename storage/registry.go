@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/nmcapule/metabox-go/config"
+)
+
+// Factory creates a Storage from a backup entry of the config. Drivers that
+// are not built in can read their own section with cfg.Section.
+type Factory func(cfg *config.BackupConfig) (Storage, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a storage driver available under name, which is matched
+// against the `driver` field of backup entries. It panics if name is already
+// registered or factory is nil.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if factory == nil {
+		panic("storage: Register factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("storage: Register called twice for driver " + name)
+	}
+	registry[name] = factory
+}
+
+// Drivers returns a sorted list of the names of registered drivers.
+func Drivers() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open creates a Storage for a backup entry using its registered driver, and
+// wraps it with the middleware configured in the entry.
+func Open(cfg *config.BackupConfig) (Storage, error) {
+	registryMu.RLock()
+	factory, ok := registry[cfg.Driver]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown storage driver: %q (available: %v)", cfg.Driver, Drivers())
+	}
+	store, err := factory(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.RateLimit > 0 {
+		store = NewThrottle(store, NewLimiter(int64(cfg.RateLimit), SystemClock))
+	}
+	if cfg.Retry.Attempts > 1 || cfg.Retry.Timeout > 0 {
+		store = NewRetry(store, &cfg.Retry)
+	}
+	return store, nil
+}
@@ -0,0 +1,118 @@
+package storage_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/nmcapule/metabox-go/config"
+	"github.com/nmcapule/metabox-go/storage"
+)
+
+// dummyConfig is the config section of the dummy driver.
+type dummyConfig struct {
+	Bucket string   `yaml:"bucket"`
+	Region string   `yaml:"region" default:"eu-west-1"`
+	Depth  int      `yaml:"depth" default:"3"`
+	Tags   []string `yaml:"tags"`
+}
+
+// dummyStore is a Memory storage that remembers its config.
+type dummyStore struct {
+	*storage.Memory
+	config dummyConfig
+}
+
+func init() {
+	storage.Register("dummy", func(cfg *config.BackupConfig) (storage.Storage, error) {
+		store := &dummyStore{Memory: storage.NewMemory()}
+		if err := cfg.Section(&store.config); err != nil {
+			return nil, err
+		}
+		if store.config.Bucket == "invalid" {
+			return nil, errors.New("dummy: invalid bucket")
+		}
+		return store, nil
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	path := filepath.Join(tempDir(t), "metabox.yml")
+	yml := `
+backups:
+  - name: configured
+    driver: dummy
+    dummy:
+      bucket: backups
+      depth: 5
+      tags: [a, b]
+  - name: defaults
+    driver: dummy
+  - name: retried
+    driver: dummy
+    retry:
+      attempts: 3
+    dummy:
+      bucket: retried
+  - name: invalid
+    driver: dummy
+    dummy:
+      bucket: invalid
+  - name: unknown
+    driver: nope
+`
+	if err := ioutil.WriteFile(path, []byte(yml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.FromFile(path)
+	if err != nil {
+		t.Fatalf("FromFile() error = %v", err)
+	}
+
+	tests := []struct {
+		want    dummyConfig
+		wantErr string
+	}{
+		{want: dummyConfig{Bucket: "backups", Region: "eu-west-1", Depth: 5, Tags: []string{"a", "b"}}},
+		{want: dummyConfig{Region: "eu-west-1", Depth: 3}},
+		{want: dummyConfig{Bucket: "retried", Region: "eu-west-1", Depth: 3}},
+		{wantErr: "invalid bucket"},
+		{wantErr: `unknown storage driver: "nope"`},
+	}
+	for i, tt := range tests {
+		backup := &cfg.Backups[i]
+		s, err := storage.Open(backup)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: Open() error = %v; want %q", backup.Name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: Open() error = %v", backup.Name, err)
+		}
+
+		// Middleware configured in the entry wraps the driver.
+		if retry, ok := s.(*storage.Retry); ok {
+			s = retry.Unwrap()
+		} else if backup.Retry.Attempts > 1 {
+			t.Errorf("%s: Open() = %T; want a *storage.Retry", backup.Name, s)
+		}
+		dummy, ok := s.(*dummyStore)
+		if !ok {
+			t.Fatalf("%s: Open() = %T; want a *dummyStore", backup.Name, s)
+		}
+		if !reflect.DeepEqual(dummy.config, tt.want) {
+			t.Errorf("%s: decoded section %+v; want %+v", backup.Name, dummy.config, tt.want)
+		}
+	}
+
+	drivers := storage.Drivers()
+	if i := sort.SearchStrings(drivers, "dummy"); !sort.StringsAreSorted(drivers) || i == len(drivers) || drivers[i] != "dummy" {
+		t.Errorf("Drivers() = %q; want a sorted list including %q", drivers, "dummy")
+	}
+}
@@ -1,27 +1,282 @@
 package storage
 
 import (
+	"crypto/rand"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
 
 	"github.com/nmcapule/metabox-go/config"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
+const defaultSSHPort = "22"
+
+// Remote implements a storage on a remote machine accessed through SFTP.
 type Remote struct {
-	config *config.RemoteStorageConfig
+	config    *config.RemoteStorageConfig
+	sshConfig *ssh.ClientConfig
+	addr      string
+
+	mu     sync.Mutex
+	conn   *ssh.Client
+	client *sftp.Client
 }
 
+func init() {
+	Register(RemoteDriver, func(cfg *config.BackupConfig) (Storage, error) {
+		return NewRemote(&cfg.Remote)
+	})
+}
+
+// NewRemote creates a Remote storage from config.
+//
+// The SSH connection is not opened until the first storage operation.
 func NewRemote(config *config.RemoteStorageConfig) (*Remote, error) {
-	return nil, errUnimplemented
+	if config.Host == "" {
+		return nil, fmt.Errorf("remote: host is required")
+	}
+	if config.CredentialsPath == "" {
+		return nil, fmt.Errorf("remote: ssh_credential_file is required")
+	}
+
+	key, err := ioutil.ReadFile(expandHome(config.CredentialsPath))
+	if err != nil {
+		return nil, fmt.Errorf("reading ssh credential: %v", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("parsing ssh credential: %v", err)
+	}
+
+	knownHostsPath := config.KnownHostsPath
+	if knownHostsPath == "" {
+		knownHostsPath = "~/.ssh/known_hosts"
+	}
+	hostKeyCallback, err := knownhosts.New(expandHome(knownHostsPath))
+	if err != nil {
+		return nil, fmt.Errorf("reading known hosts: %v", err)
+	}
+
+	user := config.User
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+
+	addr := config.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, defaultSSHPort)
+	}
+
+	return &Remote{
+		config: config,
+		sshConfig: &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+		},
+		addr: addr,
+	}, nil
+}
+
+// sftp returns the active SFTP client, connecting if necessary.
+func (s *Remote) sftp() (*sftp.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	conn, err := ssh.Dial("tcp", s.addr, s.sshConfig)
+	if err != nil {
+		return nil, fmt.Errorf("ssh dial %q: %v", s.addr, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sftp session on %q: %v", s.addr, err)
+	}
+	s.conn = conn
+	s.client = client
+	return client, nil
+}
+
+// Close closes the underlying SFTP session and SSH connection, if any.
+func (s *Remote) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client == nil {
+		return nil
+	}
+	s.client.Close()
+	err := s.conn.Close()
+	s.client, s.conn = nil, nil
+	return err
+}
+
+func (s *Remote) fullpath(key string) string {
+	return path.Join(s.config.Path, key)
 }
 
 func (s *Remote) Exists(key string) (bool, error) {
-	return false, errUnimplemented
+	client, err := s.sftp()
+	if err != nil {
+		return false, err
+	}
+
+	path := s.fullpath(key)
+	if _, err := client.Stat(path); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("stat %q: %v", path, err)
+	}
+	return true, nil
 }
 
 func (s *Remote) Upload(key string, source io.Reader) error {
-	return errUnimplemented
+	client, err := s.sftp()
+	if err != nil {
+		return err
+	}
+
+	dir := path.Dir(s.fullpath(key))
+	if err := client.MkdirAll(dir); err != nil {
+		return fmt.Errorf("mkdir %q: %v", dir, err)
+	}
+
+	// Write to a temporary file and rename it in place, so that readers and
+	// concurrent uploads never see a partial item.
+	path := s.fullpath(key)
+	tmp, err := tempName(path)
+	if err != nil {
+		return err
+	}
+	file, err := client.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_EXCL)
+	if err != nil {
+		return fmt.Errorf("open %q: %v", tmp, err)
+	}
+	defer client.Remove(tmp)
+	defer file.Close()
+
+	if _, err := io.Copy(file, source); err != nil {
+		return fmt.Errorf("upload to %q: %v", path, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("close %q: %v", tmp, err)
+	}
+	if err := client.PosixRename(tmp, path); err != nil {
+		return fmt.Errorf("rename %q: %v", tmp, err)
+	}
+	return nil
+}
+
+// tempName returns a unique name for an in-progress upload to name.
+func tempName(name string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("temp name for %q: %v", name, err)
+	}
+	return path.Join(path.Dir(name), fmt.Sprintf(".%s%s%x", path.Base(name), tmpInfix, b)), nil
 }
 
 func (s *Remote) Download(key string, destination WriterWriterAt) error {
-	return errUnimplemented
+	client, err := s.sftp()
+	if err != nil {
+		return err
+	}
+
+	path := s.fullpath(key)
+	file, err := client.Open(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("download %q: %w", key, ErrNotExist)
+	} else if err != nil {
+		return fmt.Errorf("open %q: %v", path, err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(destination, file); err != nil {
+		return fmt.Errorf("download from %q: %v", path, err)
+	}
+	return nil
+}
+
+func (s *Remote) List(prefix string) ([]string, error) {
+	client, err := s.sftp()
+	if err != nil {
+		return nil, err
+	}
+
+	root := path.Clean(s.config.Path)
+
+	// Only walk the deepest directory that can contain matching keys.
+	start := root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		start = path.Join(root, prefix[:i])
+	}
+
+	var keys []string
+	walker := client.Walk(start)
+	for walker.Step() {
+		if err := walker.Err(); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("list %q: %v", prefix, err)
+		}
+		// Skip directories and in-progress uploads.
+		if walker.Stat().IsDir() || strings.Contains(walker.Stat().Name(), tmpInfix) {
+			continue
+		}
+
+		key := walker.Path()
+		if root != "." {
+			key = strings.TrimPrefix(strings.TrimPrefix(key, root), "/")
+		}
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *Remote) Delete(key string) error {
+	client, err := s.sftp()
+	if err != nil {
+		return err
+	}
+
+	path := s.fullpath(key)
+	if err := client.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove %q: %v", path, err)
+	}
+	return nil
+}
+
+func (s *Remote) Stat(key string) (*ObjectInfo, error) {
+	client, err := s.sftp()
+	if err != nil {
+		return nil, err
+	}
+
+	path := s.fullpath(key)
+	info, err := client.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("stat %q: %w", key, ErrNotExist)
+	} else if err != nil {
+		return nil, fmt.Errorf("stat %q: %v", path, err)
+	}
+
+	return &ObjectInfo{
+		Key:     key,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}, nil
 }
@@ -0,0 +1,213 @@
+package storage_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nmcapule/metabox-go/config"
+	"github.com/nmcapule/metabox-go/storage"
+	"github.com/nmcapule/metabox-go/storage/storagetest"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestRemote(t *testing.T) {
+	srv := startSFTPServer(t)
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return newRemote(t, srv, srv.hostKey)
+	})
+}
+
+func TestRemoteHostKeyRejected(t *testing.T) {
+	srv := startSFTPServer(t)
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ssh.NewPublicKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		hostKey ssh.PublicKey
+		want    string
+	}{
+		{"Mismatch", otherKey, "key mismatch"},
+		{"Unknown", nil, "key is unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newRemote(t, srv, tt.hostKey)
+			_, err := s.Exists("anything.tar.gz")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Exists() error = %v; want %q", err, tt.want)
+			}
+			if err := s.Upload("anything.tar.gz", bytes.NewReader(nil)); err == nil {
+				t.Fatalf("Upload() = nil; want error")
+			}
+		})
+	}
+}
+
+// sftpServer is an in-process SSH server that only serves the sftp subsystem
+// to the holder of clientKey.
+type sftpServer struct {
+	addr      string
+	hostKey   ssh.PublicKey
+	clientKey ed25519.PrivateKey
+}
+
+func startSFTPServer(t *testing.T) *sftpServer {
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPub, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, fmt.Errorf("unauthorized key for %s", conn.User())
+			}
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, cfg)
+		}
+	}()
+
+	return &sftpServer{
+		addr:      ln.Addr().String(),
+		hostKey:   hostSigner.PublicKey(),
+		clientKey: clientPriv,
+	}
+}
+
+func serveSSH(conn net.Conn, cfg *ssh.ServerConfig) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "only sessions are served")
+			continue
+		}
+		ch, requests, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				// The payload of a subsystem request is a length-prefixed name.
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				go func() {
+					defer ch.Close()
+					server, err := sftp.NewServer(ch)
+					if err != nil {
+						return
+					}
+					server.Serve()
+					server.Close()
+				}()
+			}
+		}()
+	}
+}
+
+// newRemote creates a Remote storage on srv in a fresh directory, trusting
+// hostKey for srv. A nil hostKey trusts no host at all.
+func newRemote(t *testing.T, srv *sftpServer, hostKey ssh.PublicKey) *storage.Remote {
+	dir := tempDir(t)
+
+	der, err := x509.MarshalPKCS8PrivateKey(srv.clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "id_ed25519")
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var knownHosts string
+	if hostKey != nil {
+		knownHosts = knownhosts.Line([]string{knownhosts.Normalize(srv.addr)}, hostKey) + "\n"
+	}
+	knownHostsPath := filepath.Join(dir, "known_hosts")
+	if err := ioutil.WriteFile(knownHostsPath, []byte(knownHosts), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(dir, "store")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := storage.NewRemote(&config.RemoteStorageConfig{
+		Path:            filepath.ToSlash(root),
+		Host:            srv.addr,
+		User:            "metabox",
+		CredentialsPath: keyPath,
+		KnownHostsPath:  knownHostsPath,
+	})
+	if err != nil {
+		t.Fatalf("NewRemote() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// tempDir creates a directory that is removed when t finishes.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "metabox-storage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
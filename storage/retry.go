@@ -0,0 +1,306 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nmcapule/metabox-go/config"
+)
+
+const (
+	defaultRetryBackoff    = time.Second
+	defaultRetryMaxBackoff = time.Minute
+)
+
+// errTimeout is returned when an operation exceeds the configured timeout.
+var errTimeout = errors.New("operation timed out")
+
+// Retry wraps a Storage to retry failed operations with exponential backoff
+// and to bound each attempt with a timeout.
+//
+// Uploads are only retried if the source is an io.Seeker, and downloads only
+// if the destination can be truncated, e.g. an *os.File. Errors of kind
+// ErrNotExist, ErrPermission and ErrUnsupported are never retried.
+type Retry struct {
+	store  Storage
+	config *config.RetryConfig
+
+	// sleep waits between attempts. Replaceable for tests.
+	sleep func(time.Duration)
+}
+
+// NewRetry wraps store with the retry behaviour described by config.
+func NewRetry(store Storage, config *config.RetryConfig) *Retry {
+	return &Retry{
+		store:  store,
+		config: config,
+		sleep:  time.Sleep,
+	}
+}
+
+// Unwrap returns the wrapped Storage.
+func (s *Retry) Unwrap() Storage {
+	return s.store
+}
+
+// do calls fn until it succeeds, fails permanently or runs out of attempts,
+// and returns the result of the last attempt. reset is called before every
+// retry and may return an error to give up.
+func (s *Retry) do(op, key string, reset func() error, fn func(cancel <-chan struct{}) (interface{}, error)) (interface{}, error) {
+	attempts := s.config.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	backoff := s.config.Backoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	maxBackoff := s.config.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		result, err := s.attempt(op, key, fn)
+		if err == nil || !retryable(err) || attempt >= attempts {
+			return result, err
+		}
+
+		log.Printf("%s %q: attempt %d of %d failed, retrying in %v: %v", op, key, attempt, attempts, backoff, err)
+		s.sleep(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+
+		if reset != nil {
+			if rerr := reset(); rerr != nil {
+				log.Printf("%s %q: cannot retry: %v", op, key, rerr)
+				return result, err
+			}
+		}
+	}
+}
+
+// attempt calls fn once, giving up after the configured timeout. When it gives
+// up, the cancel channel passed to fn is closed and the result of fn, whenever
+// it arrives, is discarded.
+func (s *Retry) attempt(op, key string, fn func(cancel <-chan struct{}) (interface{}, error)) (interface{}, error) {
+	cancel := make(chan struct{})
+	if s.config.Timeout <= 0 {
+		return fn(cancel)
+	}
+
+	type result struct {
+		value interface{}
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := fn(cancel)
+		done <- result{value, err}
+	}()
+
+	timer := time.NewTimer(s.config.Timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-timer.C:
+		close(cancel)
+		return nil, &Error{Op: op, Key: key, Kind: ErrUnavailable, Err: fmt.Errorf("%v after %v", errTimeout, s.config.Timeout)}
+	}
+}
+
+// retryable reports whether err may go away by trying again.
+func retryable(err error) bool {
+	return !errors.Is(err, ErrNotExist) &&
+		!errors.Is(err, ErrPermission) &&
+		!errors.Is(err, ErrUnsupported)
+}
+
+func (s *Retry) Exists(key string) (bool, error) {
+	exists, err := s.do("exists", key, nil, func(<-chan struct{}) (interface{}, error) {
+		return s.store.Exists(key)
+	})
+	if err != nil {
+		return false, err
+	}
+	return exists.(bool), nil
+}
+
+func (s *Retry) Upload(key string, source io.Reader) error {
+	// Abandoned attempts may still be reading, so rewinding the source must
+	// hold the same lock as the reads.
+	var mu sync.Mutex
+	reset := func() error { return errors.New("source is not seekable") }
+	if seeker, ok := source.(io.Seeker); ok {
+		if start, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			reset = func() error {
+				mu.Lock()
+				defer mu.Unlock()
+				_, err := seeker.Seek(start, io.SeekStart)
+				return err
+			}
+		}
+	}
+
+	_, err := s.do("upload", key, reset, func(cancel <-chan struct{}) (interface{}, error) {
+		return nil, s.store.Upload(key, newCancelReader(source, &mu, cancel))
+	})
+	return err
+}
+
+func (s *Retry) Download(key string, destination WriterWriterAt) error {
+	// Discard partial writes of failed attempts before retrying. Abandoned
+	// attempts may still be writing, so this must hold the same lock.
+	var mu sync.Mutex
+	reset := func() error { return errors.New("destination is not truncatable") }
+	if t, ok := destination.(truncateSeeker); ok {
+		if start, err := t.Seek(0, io.SeekCurrent); err == nil {
+			reset = func() error {
+				mu.Lock()
+				defer mu.Unlock()
+				if err := t.Truncate(start); err != nil {
+					return err
+				}
+				_, err := t.Seek(start, io.SeekStart)
+				return err
+			}
+		}
+	}
+
+	_, err := s.do("download", key, reset, func(cancel <-chan struct{}) (interface{}, error) {
+		return nil, s.store.Download(key, &cancelWriter{mu: &mu, w: destination, cancel: cancel})
+	})
+	return err
+}
+
+func (s *Retry) List(prefix string) ([]string, error) {
+	keys, err := s.do("list", prefix, nil, func(<-chan struct{}) (interface{}, error) {
+		return s.store.List(prefix)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys.([]string), nil
+}
+
+func (s *Retry) Delete(key string) error {
+	_, err := s.do("delete", key, nil, func(<-chan struct{}) (interface{}, error) {
+		return nil, s.store.Delete(key)
+	})
+	return err
+}
+
+func (s *Retry) Stat(key string) (*ObjectInfo, error) {
+	info, err := s.do("stat", key, nil, func(<-chan struct{}) (interface{}, error) {
+		return s.store.Stat(key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info.(*ObjectInfo), nil
+}
+
+// Tag tags key in the wrapped store, retrying like the other operations. It
+// does nothing if the wrapped store is not a Tagger.
+func (s *Retry) Tag(key string, tags []string) error {
+	tagger, ok := AsTagger(s.store)
+	if !ok {
+		return nil
+	}
+	_, err := s.do("tag", key, nil, func(<-chan struct{}) (interface{}, error) {
+		return nil, tagger.Tag(key, tags)
+	})
+	return err
+}
+
+// truncateSeeker is implemented by destinations that can be rewound, such as
+// *os.File.
+type truncateSeeker interface {
+	io.Seeker
+	Truncate(size int64) error
+}
+
+// cancelReader fails all reads once cancel is closed, so that an abandoned
+// attempt stops consuming the source.
+type cancelReader struct {
+	mu     *sync.Mutex
+	r      io.Reader
+	cancel <-chan struct{}
+}
+
+// cancelReadSeeker is a cancelReader that keeps the source seekable, so
+// drivers can still determine its size.
+type cancelReadSeeker struct {
+	*cancelReader
+}
+
+func newCancelReader(r io.Reader, mu *sync.Mutex, cancel <-chan struct{}) io.Reader {
+	cr := &cancelReader{mu: mu, r: r, cancel: cancel}
+	if _, ok := r.(io.Seeker); ok {
+		return cancelReadSeeker{cr}
+	}
+	return cr
+}
+
+func (r *cancelReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	select {
+	case <-r.cancel:
+		return 0, errTimeout
+	default:
+		return r.r.Read(p)
+	}
+}
+
+func (r cancelReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	select {
+	case <-r.cancel:
+		return 0, errTimeout
+	default:
+		return r.r.(io.Seeker).Seek(offset, whence)
+	}
+}
+
+// cancelWriter fails all writes once cancel is closed, so that an abandoned
+// attempt cannot clobber the destination of the next one.
+type cancelWriter struct {
+	mu     *sync.Mutex
+	w      WriterWriterAt
+	cancel <-chan struct{}
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	select {
+	case <-w.cancel:
+		return 0, errTimeout
+	default:
+		return w.w.Write(p)
+	}
+}
+
+func (w *cancelWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	select {
+	case <-w.cancel:
+		return 0, errTimeout
+	default:
+		return w.w.WriteAt(p, off)
+	}
+}
@@ -0,0 +1,298 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nmcapule/metabox-go/config"
+)
+
+func TestRetryErrorKinds(t *testing.T) {
+	transient := &Error{Op: "exists", Key: "a", Kind: ErrUnavailable, Err: errors.New("connection reset")}
+	tests := []struct {
+		name      string
+		fault     error
+		wantCalls int
+	}{
+		{"Unavailable", transient, 3},
+		{"Kindless", errors.New("connection reset"), 3},
+		{"NotExist", &Error{Op: "exists", Key: "a", Kind: ErrNotExist, Err: ErrNotExist}, 1},
+		{"Permission", &Error{Op: "exists", Key: "a", Kind: ErrPermission, Err: ErrPermission}, 1},
+		{"Unsupported", &Error{Op: "exists", Key: "a", Kind: ErrUnsupported, Err: ErrUnsupported}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &faultStore{Storage: NewMemory(), faults: []error{tt.fault, tt.fault, tt.fault}}
+			s, _ := newTestRetry(store, &config.RetryConfig{Attempts: 3})
+
+			if _, err := s.Exists("a"); err != tt.fault {
+				t.Errorf("Exists() error = %v; want %v", err, tt.fault)
+			}
+			if store.calls != tt.wantCalls {
+				t.Errorf("Exists() made %d calls; want %d", store.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	fault := errors.New("connection reset")
+	store := &faultStore{Storage: NewMemory(), faults: []error{fault, fault, fault, fault}}
+	s, slept := newTestRetry(store, &config.RetryConfig{
+		Attempts:   5,
+		Backoff:    time.Second,
+		MaxBackoff: 3 * time.Second,
+	})
+
+	if _, err := s.Exists("a"); err != nil {
+		t.Fatalf("Exists() error = %v", err)
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}
+	if !reflect.DeepEqual(*slept, want) {
+		t.Errorf("Exists() slept %v; want %v", *slept, want)
+	}
+}
+
+func TestRetryUpload(t *testing.T) {
+	fault := errors.New("connection reset")
+	want := []byte("hello world")
+
+	store := &faultStore{Storage: NewMemory(), faults: []error{fault}}
+	s, _ := newTestRetry(store, &config.RetryConfig{Attempts: 2})
+	if err := s.Upload("a", bytes.NewReader(want)); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	var got Buffer
+	if err := store.Storage.Download("a", &got); err != nil || !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("uploaded %q, %v; want %q", got.Bytes(), err, want)
+	}
+
+	// Sources that cannot be rewound are not retried.
+	store = &faultStore{Storage: NewMemory(), faults: []error{fault}}
+	s, _ = newTestRetry(store, &config.RetryConfig{Attempts: 2})
+	if err := s.Upload("a", ioutil.NopCloser(bytes.NewReader(want))); err != fault {
+		t.Errorf("Upload() of unseekable source error = %v; want %v", err, fault)
+	}
+	if store.calls != 1 {
+		t.Errorf("Upload() of unseekable source made %d calls; want 1", store.calls)
+	}
+}
+
+func TestRetryDownload(t *testing.T) {
+	fault := errors.New("connection reset")
+	want := []byte("hello world")
+
+	store := &faultStore{Storage: NewMemory(), faults: []error{fault}}
+	if err := store.Storage.Upload("a", bytes.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := newTestRetry(store, &config.RetryConfig{Attempts: 2})
+
+	f, err := ioutil.TempFile("", "metabox-retry-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(f.Name())
+	})
+	if err := s.Download("a", f); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if got, err := ioutil.ReadFile(f.Name()); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("downloaded %q, %v; want %q", got, err, want)
+	}
+
+	// Destinations that cannot be truncated are not retried.
+	store.faults = []error{fault}
+	store.calls = 0
+	if err := s.Download("a", &Buffer{}); err != fault {
+		t.Errorf("Download() to buffer error = %v; want %v", err, fault)
+	}
+	if store.calls != 1 {
+		t.Errorf("Download() to buffer made %d calls; want 1", store.calls)
+	}
+}
+
+func TestRetryTimeout(t *testing.T) {
+	store := &faultStore{Storage: NewMemory(), hang: 1}
+	s, _ := newTestRetry(store, &config.RetryConfig{Attempts: 2, Timeout: 10 * time.Millisecond})
+
+	if _, err := s.Exists("a"); err != nil {
+		t.Errorf("Exists() error = %v", err)
+	}
+	if store.calls != 2 {
+		t.Errorf("Exists() made %d calls; want 2", store.calls)
+	}
+
+	store.hang = 2
+	if _, err := s.Exists("a"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Exists() error = %v; want ErrUnavailable", err)
+	}
+}
+
+func TestRetryLocalMissingKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metabox-retry-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	local, err := NewLocal(&config.LocalStorageConfig{Path: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, slept := newTestRetry(local, &config.RetryConfig{Attempts: 3})
+
+	f, err := ioutil.TempFile(dir, "download-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := s.Download("missing.tar.gz", f); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Download() error = %v; want ErrNotExist", err)
+	}
+	if _, err := s.Stat("missing.tar.gz"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat() error = %v; want ErrNotExist", err)
+	}
+	if len(*slept) != 0 {
+		t.Errorf("missing key was retried after %v", *slept)
+	}
+}
+
+func TestRetryTag(t *testing.T) {
+	fault := errors.New("connection reset")
+	store := &faultStore{Storage: NewMemory(), faults: []error{fault}}
+	s, _ := newTestRetry(store, &config.RetryConfig{Attempts: 2})
+
+	tagger, ok := AsTagger(NewThrottle(s, nil))
+	if !ok || tagger != Tagger(s) {
+		t.Fatalf("AsTagger() = %v, %v; want the Retry", tagger, ok)
+	}
+	if err := tagger.Tag("a", []string{"nightly"}); err != nil {
+		t.Fatalf("Tag() error = %v", err)
+	}
+	if store.calls != 2 || !reflect.DeepEqual(store.tags["a"], []string{"nightly"}) {
+		t.Errorf("Tag() made %d calls and tagged %q; want 2 and %q", store.calls, store.tags["a"], []string{"nightly"})
+	}
+
+	// Stores that cannot tag are left alone.
+	s, _ = newTestRetry(NewMemory(), &config.RetryConfig{Attempts: 2})
+	if err := s.Tag("a", []string{"nightly"}); err != nil {
+		t.Errorf("Tag() on a Memory error = %v", err)
+	}
+}
+
+// newTestRetry wraps store with config and records backoffs instead of
+// sleeping.
+func newTestRetry(store Storage, config *config.RetryConfig) (*Retry, *[]time.Duration) {
+	var slept []time.Duration
+	s := NewRetry(store, config)
+	s.sleep = func(d time.Duration) { slept = append(slept, d) }
+	return s, &slept
+}
+
+// faultStore fails the next calls to its Storage with faults, one per call,
+// after transferring part of the data. Calls are counted in calls. The next
+// hang calls block until the Retry gives up on them. Tags are kept in tags.
+type faultStore struct {
+	Storage
+
+	mu     sync.Mutex
+	faults []error
+	hang   int
+	calls  int
+	tags   map[string][]string
+}
+
+// fault returns the error for the next call, if any.
+func (s *faultStore) fault() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.hang > 0 {
+		s.hang--
+		return errHang
+	}
+	if len(s.faults) == 0 {
+		return nil
+	}
+	err := s.faults[0]
+	s.faults = s.faults[1:]
+	return err
+}
+
+// errHang marks calls that block until their Retry attempt times out.
+var errHang = errors.New("hang")
+
+func (s *faultStore) wait(err error) error {
+	if err == errHang {
+		time.Sleep(time.Second)
+		return errors.New("hung call returned")
+	}
+	return err
+}
+
+func (s *faultStore) Exists(key string) (bool, error) {
+	if err := s.fault(); err != nil {
+		return false, s.wait(err)
+	}
+	return s.Storage.Exists(key)
+}
+
+func (s *faultStore) Upload(key string, source io.Reader) error {
+	if err := s.fault(); err != nil {
+		io.CopyN(ioutil.Discard, source, 4)
+		return s.wait(err)
+	}
+	return s.Storage.Upload(key, source)
+}
+
+func (s *faultStore) Download(key string, destination WriterWriterAt) error {
+	if err := s.fault(); err != nil {
+		destination.Write([]byte("partial"))
+		return s.wait(err)
+	}
+	return s.Storage.Download(key, destination)
+}
+
+func (s *faultStore) List(prefix string) ([]string, error) {
+	if err := s.fault(); err != nil {
+		return nil, s.wait(err)
+	}
+	return s.Storage.List(prefix)
+}
+
+func (s *faultStore) Delete(key string) error {
+	if err := s.fault(); err != nil {
+		return s.wait(err)
+	}
+	return s.Storage.Delete(key)
+}
+
+func (s *faultStore) Stat(key string) (*ObjectInfo, error) {
+	if err := s.fault(); err != nil {
+		return nil, s.wait(err)
+	}
+	return s.Storage.Stat(key)
+}
+
+func (s *faultStore) Tag(key string, tags []string) error {
+	if err := s.fault(); err != nil {
+		return s.wait(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tags == nil {
+		s.tags = make(map[string][]string)
+	}
+	s.tags[key] = tags
+	return nil
+}
@@ -1,10 +1,16 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -17,14 +23,67 @@ type S3 struct {
 	session *session.Session
 }
 
-func NewS3(config *config.S3StorageConfig) (*S3, error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region:      &config.Region,
-		Credentials: credentials.NewStaticCredentials(config.AccessKeyID, config.SecretAccessKey, ""),
-		Endpoint:    &config.Endpoint,
+func init() {
+	Register(S3Driver, func(cfg *config.BackupConfig) (Storage, error) {
+		return NewS3(&cfg.S3)
 	})
+}
+
+func NewS3(config *config.S3StorageConfig) (*S3, error) {
+	switch config.ServerSideEncryption {
+	case "", s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms:
+	default:
+		return nil, fmt.Errorf("s3: server_side_encryption must be %q or %q", s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms)
+	}
+	if config.KMSKeyID != "" && config.ServerSideEncryption == s3.ServerSideEncryptionAes256 {
+		return nil, fmt.Errorf("s3: kms_key_id requires server_side_encryption %q", s3.ServerSideEncryptionAwsKms)
+	}
+	switch strings.ToUpper(config.ObjectLock.Mode) {
+	case "":
+	case s3.ObjectLockModeGovernance, s3.ObjectLockModeCompliance:
+		if config.ObjectLock.RetainFor <= 0 {
+			return nil, fmt.Errorf("s3: object_lock.retain_for is required with object_lock.mode")
+		}
+	default:
+		return nil, fmt.Errorf("s3: object_lock.mode must be %q or %q", s3.ObjectLockModeGovernance, s3.ObjectLockModeCompliance)
+	}
+
+	awsConfig := &aws.Config{
+		S3ForcePathStyle: aws.Bool(config.ForcePathStyle),
+		DisableSSL:       aws.Bool(config.DisableSSL),
+	}
+	if config.Region != "" {
+		awsConfig.Region = aws.String(config.Region)
+	}
+	if config.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(config.Endpoint)
+	}
+	// Without keys, fall back to the default credential chain: environment,
+	// shared files and instance roles.
+	if config.AccessKeyID != "" || config.SecretAccessKey != "" {
+		awsConfig.Credentials = credentials.NewStaticCredentials(config.AccessKeyID, config.SecretAccessKey, "")
+	}
+
+	opts := session.Options{
+		Config:            *awsConfig,
+		Profile:           config.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	}
+	if config.CAFile != "" {
+		bundle, err := os.Open(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("s3: reading ca file: %v", err)
+		}
+		defer bundle.Close()
+		opts.CustomCABundle = bundle
+		// The SDK installs the bundle on the session's client, which would
+		// otherwise be the http.DefaultClient shared by every other store.
+		opts.Config.HTTPClient = &http.Client{}
+	}
+
+	sess, err := session.NewSessionWithOptions(opts)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("s3: %v", err)
 	}
 	return &S3{
 		config:  config,
@@ -33,26 +92,88 @@ func NewS3(config *config.S3StorageConfig) (*S3, error) {
 }
 
 func (s *S3) Exists(key string) (bool, error) {
-	input := &s3.GetObjectInput{
+	_, err := s3.New(s.session).HeadObject(&s3.HeadObjectInput{
 		Bucket: aws.String(s.config.Bucket),
 		Key:    aws.String(s.config.PrefixPath + key),
-	}
-	_, err := s3.New(s.session).GetObject(input)
-	if err != nil {
-		return false, fmt.Errorf("GetObject(%+v): %v", input, err)
+	})
+	if err := s3Error("exists", key, err); errors.Is(err, ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
 	}
 	return true, nil
 }
 
 func (s *S3) Upload(key string, source io.Reader) error {
-	uploader := s3manager.NewUploader(s.session)
-	_, err := uploader.Upload(&s3manager.UploadInput{
+	input := &s3manager.UploadInput{
 		Bucket: aws.String(s.config.Bucket),
 		Key:    aws.String(s.config.PrefixPath + key),
 		Body:   source,
+	}
+	if s.config.StorageClass != "" {
+		input.StorageClass = aws.String(s.config.StorageClass)
+	}
+	if s.config.ACL != "" {
+		input.ACL = aws.String(s.config.ACL)
+	}
+	if s.config.KMSKeyID != "" {
+		input.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAwsKms)
+		input.SSEKMSKeyId = aws.String(s.config.KMSKeyID)
+	}
+	if s.config.ServerSideEncryption != "" {
+		input.ServerSideEncryption = aws.String(s.config.ServerSideEncryption)
+	}
+	if lock := s.config.ObjectLock; lock.Mode != "" {
+		input.ObjectLockMode = aws.String(strings.ToUpper(lock.Mode))
+		input.ObjectLockRetainUntilDate = aws.Time(time.Now().Add(lock.RetainFor))
+	}
+	if s.config.ObjectLock.LegalHold {
+		input.ObjectLockLegalHoldStatus = aws.String(s3.ObjectLockLegalHoldStatusOn)
+	}
+
+	// Resume large uploads if their progress can be saved.
+	if seeker, ok := source.(io.ReadSeeker); ok && s.config.StateDir != "" {
+		if size, ok := readerSize(source); ok && size > s.partSize(size) {
+			return s.uploadResumable(key, input, seeker, size)
+		}
+	}
+
+	uploader := s3manager.NewUploader(s.session, func(u *s3manager.Uploader) {
+		if s.config.PartSize > 0 {
+			u.PartSize = int64(s.config.PartSize)
+		}
+		u.Concurrency = s.concurrency()
+	})
+	if _, err := uploader.Upload(input); err != nil {
+		return s3Error("upload", key, err)
+	}
+	return nil
+}
+
+// Tag replaces the object tags of key name with the metabox tags, if
+// tag_objects is enabled. Tags of the form `key:value` are split into an
+// object tag key and value.
+func (s *S3) Tag(key string, tags []string) error {
+	if !s.config.TagObjects {
+		return nil
+	}
+
+	tagging := &s3.Tagging{TagSet: []*s3.Tag{}}
+	for _, tag := range tags {
+		k, v := tag, ""
+		if i := strings.Index(tag, ":"); i >= 0 {
+			k, v = tag[:i], tag[i+1:]
+		}
+		tagging.TagSet = append(tagging.TagSet, &s3.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	_, err := s3.New(s.session).PutObjectTagging(&s3.PutObjectTaggingInput{
+		Bucket:  aws.String(s.config.Bucket),
+		Key:     aws.String(s.config.PrefixPath + key),
+		Tagging: tagging,
 	})
 	if err != nil {
-		return fmt.Errorf("upload %q: %v", key, err)
+		return s3Error("tag", key, err)
 	}
 	return nil
 }
@@ -64,7 +185,81 @@ func (s *S3) Download(key string, destination WriterWriterAt) error {
 		Key:    aws.String(s.config.PrefixPath + key),
 	})
 	if err != nil {
-		return fmt.Errorf("download %q: %v", key, err)
+		return s3Error("download", key, err)
+	}
+	return nil
+}
+
+func (s *S3) List(prefix string) ([]string, error) {
+	var keys []string
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.config.Bucket),
+		Prefix: aws.String(s.config.PrefixPath + prefix),
+	}
+	err := s3.New(s.session).ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, strings.TrimPrefix(aws.StringValue(object.Key), s.config.PrefixPath))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, s3Error("list", prefix, err)
+	}
+	return keys, nil
+}
+
+func (s *S3) Delete(key string) error {
+	_, err := s3.New(s.session).DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(s.config.PrefixPath + key),
+	})
+	if err != nil {
+		return s3Error("delete", key, err)
 	}
 	return nil
 }
+
+func (s *S3) Stat(key string) (*ObjectInfo, error) {
+	output, err := s3.New(s.session).HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(s.config.PrefixPath + key),
+	})
+	if err != nil {
+		return nil, s3Error("stat", key, err)
+	}
+
+	return &ObjectInfo{
+		Key:      key,
+		Size:     aws.Int64Value(output.ContentLength),
+		ModTime:  aws.TimeValue(output.LastModified),
+		Checksum: strings.Trim(aws.StringValue(output.ETag), `"`),
+	}, nil
+}
+
+// s3Error classifies an AWS SDK error into a storage *Error.
+func s3Error(op, key string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	kind := ErrUnavailable
+	if aerr, ok := err.(awserr.RequestFailure); ok {
+		switch code := aerr.StatusCode(); {
+		case code == http.StatusNotFound:
+			kind = ErrNotExist
+		case code == http.StatusUnauthorized || code == http.StatusForbidden:
+			kind = ErrPermission
+		case code < http.StatusInternalServerError:
+			kind = nil
+		}
+	} else if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey:
+			kind = ErrNotExist
+		case "NoCredentialProviders", "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken":
+			kind = ErrPermission
+		}
+	}
+
+	return &Error{Op: op, Key: key, Kind: kind, Err: err}
+}
@@ -0,0 +1,741 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/nmcapule/metabox-go/config"
+	"github.com/nmcapule/metabox-go/internal/testenv"
+)
+
+const testBucket = "bucket"
+
+func TestS3Exists(t *testing.T) {
+	fake := newFakeS3()
+	fake.objects["present.tar.gz"] = []byte("present")
+	fake.fail = failKey("secret.tar.gz", http.StatusForbidden)
+	s := newTestS3(t, fake, nil)
+
+	tests := []struct {
+		key    string
+		want   bool
+		wantIs error
+	}{
+		{"present.tar.gz", true, nil},
+		{"missing.tar.gz", false, nil},
+		{"secret.tar.gz", false, ErrPermission},
+	}
+	for _, tt := range tests {
+		got, err := s.Exists(tt.key)
+		if got != tt.want || (tt.wantIs == nil && err != nil) || (tt.wantIs != nil && !errors.Is(err, tt.wantIs)) {
+			t.Errorf("Exists(%q) = %v, %v; want %v, %v", tt.key, got, err, tt.want, tt.wantIs)
+		}
+	}
+}
+
+func TestS3Errors(t *testing.T) {
+	fake := newFakeS3()
+	fake.fail = failKey("secret.tar.gz", http.StatusForbidden)
+	s := newTestS3(t, fake, nil)
+
+	if _, err := s.Stat("missing.tar.gz"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat(missing) error = %v; want ErrNotExist", err)
+	}
+	if err := s.Download("missing.tar.gz", &Buffer{}); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Download(missing) error = %v; want ErrNotExist", err)
+	}
+	if _, err := s.Stat("secret.tar.gz"); !errors.Is(err, ErrPermission) {
+		t.Errorf("Stat(secret) error = %v; want ErrPermission", err)
+	}
+	if err := s.Download("secret.tar.gz", &Buffer{}); !errors.Is(err, ErrPermission) {
+		t.Errorf("Download(secret) error = %v; want ErrPermission", err)
+	}
+	if err := s.Upload("secret.tar.gz", bytes.NewReader([]byte("x"))); !errors.Is(err, ErrPermission) {
+		t.Errorf("Upload(secret) error = %v; want ErrPermission", err)
+	}
+}
+
+func TestS3RoundTrip(t *testing.T) {
+	s := newTestS3(t, newFakeS3(), nil)
+
+	want := []byte("hello world")
+	if err := s.Upload("a/b.tar.gz", bytes.NewReader(want)); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	var got Buffer
+	if err := s.Download("a/b.tar.gz", &got); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Fatalf("Download() = %q; want %q", got.Bytes(), want)
+	}
+	keys, err := s.List("a/")
+	if err != nil || len(keys) != 1 || keys[0] != "a/b.tar.gz" {
+		t.Fatalf("List() = %v, %v; want [a/b.tar.gz]", keys, err)
+	}
+}
+
+func TestNewS3Credentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metabox-s3-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	sharedFile := filepath.Join(dir, "credentials")
+	shared := "[default]\naws_access_key_id = AKIDDEFAULT\naws_secret_access_key = secret\n\n" +
+		"[backup]\naws_access_key_id = AKIDPROFILE\naws_secret_access_key = secret\n"
+	if err := ioutil.WriteFile(sharedFile, []byte(shared), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		cfg     config.S3StorageConfig
+		wantKey string
+	}{
+		{"Static", map[string]string{"AWS_ACCESS_KEY_ID": "AKIDENV", "AWS_SECRET_ACCESS_KEY": "secret"}, config.S3StorageConfig{AccessKeyID: "AKIDSTATIC", SecretAccessKey: "secret"}, "AKIDSTATIC"},
+		{"Environment", map[string]string{"AWS_ACCESS_KEY_ID": "AKIDENV", "AWS_SECRET_ACCESS_KEY": "secret"}, config.S3StorageConfig{}, "AKIDENV"},
+		{"SharedDefault", map[string]string{"AWS_SHARED_CREDENTIALS_FILE": sharedFile}, config.S3StorageConfig{}, "AKIDDEFAULT"},
+		{"SharedProfile", map[string]string{"AWS_SHARED_CREDENTIALS_FILE": sharedFile}, config.S3StorageConfig{Profile: "backup"}, "AKIDPROFILE"},
+		{"None", nil, config.S3StorageConfig{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Start from an empty credential chain that never asks EC2.
+			for _, k := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE", "AWS_DEFAULT_PROFILE"} {
+				testenv.Setenv(t, k, "")
+			}
+			testenv.Setenv(t, "AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "none"))
+			testenv.Setenv(t, "AWS_CONFIG_FILE", filepath.Join(dir, "none"))
+			testenv.Setenv(t, "AWS_EC2_METADATA_DISABLED", "true")
+			for k, v := range tt.env {
+				testenv.Setenv(t, k, v)
+			}
+
+			fake := newFakeS3()
+			s := newTestS3(t, fake, func(cfg *config.S3StorageConfig) {
+				cfg.AccessKeyID = tt.cfg.AccessKeyID
+				cfg.SecretAccessKey = tt.cfg.SecretAccessKey
+				cfg.Profile = tt.cfg.Profile
+			})
+			_, err := s.Exists("a.tar.gz")
+
+			if tt.wantKey == "" {
+				if !errors.Is(err, ErrPermission) {
+					t.Errorf("Exists() without credentials error = %v; want ErrPermission", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exists() error = %v", err)
+			}
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			auth := fake.requests[len(fake.requests)-1].Header.Get("Authorization")
+			if !strings.Contains(auth, "Credential="+tt.wantKey+"/") {
+				t.Errorf("Exists() signed with %q; want access key %s", auth, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestNewS3ForcePathStyle(t *testing.T) {
+	tests := []struct {
+		forcePathStyle bool
+		wantHost       string
+		wantPath       string
+	}{
+		{true, "s3.example.test", "/" + testBucket + "/a.tar.gz"},
+		{false, testBucket + ".s3.example.test", "/a.tar.gz"},
+	}
+	for _, tt := range tests {
+		s, err := NewS3(&config.S3StorageConfig{
+			AccessKeyID:     "AKIDTEST",
+			SecretAccessKey: "secret",
+			Region:          "us-east-1",
+			Bucket:          testBucket,
+			Endpoint:        "https://s3.example.test",
+			ForcePathStyle:  tt.forcePathStyle,
+		})
+		if err != nil {
+			t.Fatalf("NewS3() error = %v", err)
+		}
+		req, _ := s3.New(s.session).HeadObjectRequest(&s3.HeadObjectInput{
+			Bucket: aws.String(testBucket),
+			Key:    aws.String("a.tar.gz"),
+		})
+		if err := req.Build(); err != nil {
+			t.Fatalf("Build() error = %v", err)
+		}
+		if u := req.HTTPRequest.URL; u.Host != tt.wantHost || u.Path != tt.wantPath {
+			t.Errorf("force_path_style %v: request to %s%s; want %s%s", tt.forcePathStyle, u.Host, u.Path, tt.wantHost, tt.wantPath)
+		}
+	}
+}
+
+func TestNewS3CAFile(t *testing.T) {
+	srv := httptest.NewUnstartedServer(newFakeS3())
+	// The untrusted client below makes the handshake fail on purpose.
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	dir, err := ioutil.TempDir("", "metabox-s3-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	newS3 := func(caFile string) (*S3, error) {
+		return NewS3(&config.S3StorageConfig{
+			AccessKeyID:     "AKIDTEST",
+			SecretAccessKey: "secret",
+			Region:          "us-east-1",
+			Bucket:          testBucket,
+			Endpoint:        srv.URL,
+			ForcePathStyle:  true,
+			CAFile:          caFile,
+		})
+	}
+
+	trusted, err := newS3(caFile)
+	if err != nil {
+		t.Fatalf("NewS3() error = %v", err)
+	}
+	if err := trusted.Upload("a.tar.gz", bytes.NewReader([]byte("a"))); err != nil {
+		t.Errorf("Upload() with ca_file error = %v", err)
+	}
+
+	untrusted, err := newS3("")
+	if err != nil {
+		t.Fatalf("NewS3() error = %v", err)
+	}
+	if _, err := untrusted.Exists("a.tar.gz"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Exists() without ca_file error = %v; want ErrUnavailable", err)
+	}
+
+	if _, err := newS3(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Errorf("NewS3() with missing ca_file = nil; want error")
+	}
+}
+
+func TestS3ResumeUpload(t *testing.T) {
+	const partSize = 5 << 20
+	original := bytes.Repeat([]byte("metabox!"), (2*partSize+partSize/2)/8)
+
+	tests := []struct {
+		name string
+		// change, if set, modifies the source before the upload is retried.
+		change        func(data []byte)
+		wantPart1Puts int
+	}{
+		{"Unchanged", nil, 1},
+		{"PartChanged", func(data []byte) { data[0] = '#' }, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stateDir, err := ioutil.TempDir("", "metabox-s3-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { os.RemoveAll(stateDir) })
+
+			fake := newFakeS3()
+			fake.fail = func(r *http.Request) int {
+				if r.URL.Query().Get("partNumber") == "2" {
+					return http.StatusForbidden
+				}
+				return 0
+			}
+			s := newTestS3(t, fake, func(cfg *config.S3StorageConfig) {
+				cfg.StateDir = stateDir
+				cfg.PartSize = partSize
+				cfg.Concurrency = 1
+			})
+
+			data := append([]byte(nil), original...)
+			if err := s.Upload("a.tar.gz", bytes.NewReader(data)); err == nil {
+				t.Fatalf("Upload() with failing part = nil; want error")
+			}
+			if _, err := os.Stat(s.statePath("a.tar.gz")); err != nil {
+				t.Fatalf("multipart state after failed Upload(): %v", err)
+			}
+
+			if tt.change != nil {
+				tt.change(data)
+			}
+			fake.mu.Lock()
+			fake.fail = nil
+			fake.mu.Unlock()
+			if err := s.Upload("a.tar.gz", bytes.NewReader(data)); err != nil {
+				t.Fatalf("resumed Upload() error = %v", err)
+			}
+
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			if !bytes.Equal(fake.objects["a.tar.gz"], data) {
+				t.Errorf("resumed Upload() stored %d bytes that differ from the source", len(fake.objects["a.tar.gz"]))
+			}
+			if fake.nextUpload != 1 || len(fake.uploads) != 0 {
+				t.Errorf("Upload() started %d multipart uploads and left %d open; want 1 completed", fake.nextUpload, len(fake.uploads))
+			}
+			var puts int
+			for _, r := range fake.requests {
+				if r.Method == http.MethodPut && r.URL.Query().Get("partNumber") == "1" {
+					puts++
+				}
+			}
+			if puts != tt.wantPart1Puts {
+				t.Errorf("part 1 was uploaded %d times; want %d", puts, tt.wantPart1Puts)
+			}
+			if _, err := os.Stat(s.statePath("a.tar.gz")); !os.IsNotExist(err) {
+				t.Errorf("multipart state after Upload() error = %v; want not exist", err)
+			}
+		})
+	}
+}
+
+func TestS3UploadOptions(t *testing.T) {
+	const partSize = 5 << 20
+	headers := []string{
+		"X-Amz-Storage-Class",
+		"X-Amz-Server-Side-Encryption",
+		"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id",
+		"X-Amz-Acl",
+		"X-Amz-Object-Lock-Mode",
+		"X-Amz-Object-Lock-Legal-Hold",
+	}
+	tests := []struct {
+		name   string
+		mutate func(cfg *config.S3StorageConfig)
+		// want are the expected headers, the others must not be set.
+		want      map[string]string
+		retainFor time.Duration
+	}{
+		{"None", nil, nil, 0},
+		{"StorageClass", func(cfg *config.S3StorageConfig) {
+			cfg.StorageClass = "STANDARD_IA"
+		}, map[string]string{"X-Amz-Storage-Class": "STANDARD_IA"}, 0},
+		{"ServerSideEncryption", func(cfg *config.S3StorageConfig) {
+			cfg.ServerSideEncryption = s3.ServerSideEncryptionAes256
+		}, map[string]string{"X-Amz-Server-Side-Encryption": "AES256"}, 0},
+		{"KMSKeyID", func(cfg *config.S3StorageConfig) {
+			cfg.KMSKeyID = "alias/metabox"
+		}, map[string]string{
+			"X-Amz-Server-Side-Encryption":                "aws:kms",
+			"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "alias/metabox",
+		}, 0},
+		{"ACL", func(cfg *config.S3StorageConfig) {
+			cfg.ACL = s3.ObjectCannedACLBucketOwnerFullControl
+		}, map[string]string{"X-Amz-Acl": "bucket-owner-full-control"}, 0},
+		{"ObjectLock", func(cfg *config.S3StorageConfig) {
+			cfg.ObjectLock = config.S3ObjectLockConfig{Mode: "governance", RetainFor: 24 * time.Hour}
+		}, map[string]string{"X-Amz-Object-Lock-Mode": "GOVERNANCE"}, 24 * time.Hour},
+		{"LegalHold", func(cfg *config.S3StorageConfig) {
+			cfg.ObjectLock.LegalHold = true
+		}, map[string]string{"X-Amz-Object-Lock-Legal-Hold": "ON"}, 0},
+	}
+	for _, tt := range tests {
+		// Small archives are sent with a single PUT, large ones as resumable
+		// multipart uploads which take the options on creation.
+		for _, size := range []int{1 << 10, partSize + 1} {
+			multipart := size > partSize
+			t.Run(fmt.Sprintf("%s/multipart=%v", tt.name, multipart), func(t *testing.T) {
+				stateDir, err := ioutil.TempDir("", "metabox-s3-")
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { os.RemoveAll(stateDir) })
+
+				fake := newFakeS3()
+				s := newTestS3(t, fake, func(cfg *config.S3StorageConfig) {
+					cfg.StateDir = stateDir
+					cfg.PartSize = partSize
+					if tt.mutate != nil {
+						tt.mutate(cfg)
+					}
+				})
+				before := time.Now()
+				if err := s.Upload("a.tar.gz", bytes.NewReader(make([]byte, size))); err != nil {
+					t.Fatalf("Upload() error = %v", err)
+				}
+				after := time.Now()
+
+				fake.mu.Lock()
+				defer fake.mu.Unlock()
+				var create *http.Request
+				for _, r := range fake.requests {
+					query := r.URL.Query()
+					if multipart && r.Method == http.MethodPost && len(query["uploads"]) > 0 ||
+						!multipart && r.Method == http.MethodPut && len(query) == 0 {
+						create = r
+					}
+				}
+				if create == nil {
+					t.Fatalf("Upload() made no request creating the object")
+				}
+				for _, name := range headers {
+					if got := create.Header.Get(name); got != tt.want[name] {
+						t.Errorf("%s = %q; want %q", name, got, tt.want[name])
+					}
+				}
+
+				retain := create.Header.Get("X-Amz-Object-Lock-Retain-Until-Date")
+				if tt.retainFor == 0 {
+					if retain != "" {
+						t.Errorf("X-Amz-Object-Lock-Retain-Until-Date = %q; want none", retain)
+					}
+					return
+				}
+				until, err := time.Parse(time.RFC3339, retain)
+				if err != nil {
+					t.Fatalf("X-Amz-Object-Lock-Retain-Until-Date = %q: %v", retain, err)
+				}
+				// The header has a precision of seconds.
+				if until.Before(before.Add(tt.retainFor).Truncate(time.Second)) || until.After(after.Add(tt.retainFor)) {
+					t.Errorf("X-Amz-Object-Lock-Retain-Until-Date = %v; want %v from now", until, tt.retainFor)
+				}
+			})
+		}
+	}
+}
+
+func TestS3Tag(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		fake := newFakeS3()
+		s := newTestS3(t, fake, func(cfg *config.S3StorageConfig) {
+			cfg.TagObjects = enabled
+		})
+		if err := s.Tag("a.tar.gz", []string{"nightly", "env:prod"}); err != nil {
+			t.Fatalf("Tag() error = %v", err)
+		}
+
+		fake.mu.Lock()
+		tagging, ok := fake.tagging["a.tar.gz"]
+		fake.mu.Unlock()
+		if ok != enabled {
+			t.Errorf("Tag() with tag_objects %v tagged the object: %v", enabled, ok)
+		}
+		if !enabled {
+			continue
+		}
+		var got struct {
+			Tags []struct {
+				Key   string
+				Value string
+			} `xml:"TagSet>Tag"`
+		}
+		if err := xml.Unmarshal(tagging, &got); err != nil {
+			t.Fatalf("parsing tagging %q: %v", tagging, err)
+		}
+		var tags []string
+		for _, tag := range got.Tags {
+			tags = append(tags, tag.Key+"="+tag.Value)
+		}
+		if want := []string{"nightly=", "env=prod"}; !reflect.DeepEqual(tags, want) {
+			t.Errorf("Tag() set object tags %q; want %q", tags, want)
+		}
+	}
+}
+
+// newTestS3 creates an S3 storage on a path-style endpoint served by fake.
+// Mutate, if not nil, adjusts the config first.
+func newTestS3(t *testing.T, fake *fakeS3, mutate func(cfg *config.S3StorageConfig)) *S3 {
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	cfg := &config.S3StorageConfig{
+		AccessKeyID:     "AKIDTEST",
+		SecretAccessKey: "secret",
+		Region:          "us-east-1",
+		Bucket:          testBucket,
+		Endpoint:        srv.URL,
+		ForcePathStyle:  true,
+	}
+	if mutate != nil {
+		mutate(cfg)
+	}
+	s, err := NewS3(cfg)
+	if err != nil {
+		t.Fatalf("NewS3() error = %v", err)
+	}
+	return s
+}
+
+// fakeS3 is a minimal in-memory S3 stand-in for path-style requests to
+// testBucket, including multipart uploads.
+type fakeS3 struct {
+	mu         sync.Mutex
+	objects    map[string][]byte
+	uploads    map[string]*fakeUpload
+	nextUpload int
+	// tagging holds the last tagging request body of each key.
+	tagging map[string][]byte
+	// fail, if set, returns a status code to fail the request with, or 0.
+	fail func(r *http.Request) int
+	// requests records every request served.
+	requests []*http.Request
+}
+
+// failKey fails every request for key with code.
+func failKey(key string, code int) func(r *http.Request) int {
+	return func(r *http.Request) int {
+		if strings.HasSuffix(r.URL.Path, "/"+key) {
+			return code
+		}
+		return 0
+	}
+}
+
+// fakeUpload is an ongoing multipart upload of key.
+type fakeUpload struct {
+	key   string
+	parts map[int64][]byte
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		objects: make(map[string][]byte),
+		uploads: make(map[string]*fakeUpload),
+		tagging: make(map[string][]byte),
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r)
+
+	if f.fail != nil {
+		if code := f.fail(r); code != 0 {
+			writeS3Error(w, r, code, http.StatusText(code))
+			return
+		}
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path != testBucket && !strings.HasPrefix(path, testBucket+"/") {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(path, testBucket), "/")
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeS3Error(w, r, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+
+	query := r.URL.Query()
+	has := func(name string) bool {
+		_, ok := query[name]
+		return ok
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, query.Get("prefix"))
+	case r.Method == http.MethodPut && has("tagging"):
+		f.tagging[key] = body
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPost && has("uploads"):
+		f.nextUpload++
+		id := fmt.Sprintf("upload-%d", f.nextUpload)
+		f.uploads[id] = &fakeUpload{key: key, parts: make(map[int64][]byte)}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: testBucket, Key: key, UploadId: id})
+	case has("uploadId"):
+		upload, ok := f.uploads[query.Get("uploadId")]
+		if !ok || upload.key != key {
+			writeS3Error(w, r, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		f.multipart(w, r, upload, body)
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			writeS3Error(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etag(data))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		serveRange(w, r, data)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// multipart serves the requests on an ongoing multipart upload.
+func (f *fakeS3) multipart(w http.ResponseWriter, r *http.Request, upload *fakeUpload, body []byte) {
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodPut:
+		var number int64
+		if _, err := fmt.Sscan(query.Get("partNumber"), &number); err != nil || number < 1 {
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument")
+			return
+		}
+		upload.parts[number] = body
+		w.Header().Set("ETag", etag(body))
+
+	case http.MethodGet:
+		type part struct {
+			PartNumber int64
+			ETag       string
+			Size       int
+		}
+		var result struct {
+			XMLName     xml.Name `xml:"ListPartsResult"`
+			Bucket      string
+			Key         string
+			UploadId    string
+			IsTruncated bool
+			Part        []part
+		}
+		result.Bucket, result.Key, result.UploadId = testBucket, upload.key, query.Get("uploadId")
+		for number, data := range upload.parts {
+			result.Part = append(result.Part, part{PartNumber: number, ETag: etag(data), Size: len(data)})
+		}
+		sort.Slice(result.Part, func(i, j int) bool { return result.Part[i].PartNumber < result.Part[j].PartNumber })
+		writeXML(w, result)
+
+	case http.MethodPost:
+		var complete struct {
+			Part []struct {
+				PartNumber int64
+				ETag       string
+			}
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil || len(complete.Part) == 0 {
+			writeS3Error(w, r, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		// Like S3, require strictly ascending part numbers.
+		var data []byte
+		for i, part := range complete.Part {
+			if i > 0 && part.PartNumber <= complete.Part[i-1].PartNumber {
+				writeS3Error(w, r, http.StatusBadRequest, "InvalidPartOrder")
+				return
+			}
+			p, ok := upload.parts[part.PartNumber]
+			if !ok || etag(p) != part.ETag {
+				writeS3Error(w, r, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			data = append(data, p...)
+		}
+		f.objects[upload.key] = data
+		delete(f.uploads, query.Get("uploadId"))
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: testBucket, Key: upload.key, ETag: etag(data)})
+
+	case http.MethodDelete:
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	type content struct {
+		Key  string
+		Size int
+	}
+	var result struct {
+		XMLName  xml.Name `xml:"ListBucketResult"`
+		Name     string
+		KeyCount int
+		Contents []content
+	}
+	result.Name = testBucket
+	for key, data := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, content{Key: key, Size: len(data)})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+	writeXML(w, result)
+}
+
+// serveRange writes data, or the part of it asked for by a Range header.
+func serveRange(w http.ResponseWriter, r *http.Request, data []byte) {
+	start, end := 0, len(data)-1
+	if rng := r.Header.Get("Range"); rng != "" {
+		fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+		if end >= len(data) {
+			end = len(data) - 1
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		w.Header().Set("Content-Length", fmt.Sprint(end-start+1))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+	}
+	if r.Method == http.MethodGet && len(data) > 0 {
+		w.Write(data[start : end+1])
+	}
+}
+
+func writeS3Error(w http.ResponseWriter, r *http.Request, code int, s3Code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(code)
+	// Responses to HEAD requests have no body.
+	if r.Method != http.MethodHead {
+		fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", s3Code, http.StatusText(code))
+	}
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	b, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(b)
+}
+
+func etag(data []byte) string {
+	return fmt.Sprintf(`"%x"`, md5.Sum(data))
+}
@@ -0,0 +1,321 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// multipartState is the progress of a resumable multipart upload. It is saved
+// as JSON in the state dir after every completed part.
+type multipartState struct {
+	Bucket   string          `json:"bucket"`
+	Key      string          `json:"key"`
+	UploadID string          `json:"upload_id"`
+	Size     int64           `json:"size"`
+	PartSize int64           `json:"part_size"`
+	Parts    []multipartPart `json:"parts"`
+}
+
+// multipartPart is a completed part. MD5 is the digest of the part contents,
+// used to check that the source did not change before the part is skipped.
+type multipartPart struct {
+	Number int64  `json:"number"`
+	ETag   string `json:"etag"`
+	MD5    string `json:"md5"`
+}
+
+// setPart records part as completed, replacing an earlier upload of the same
+// part number.
+func (state *multipartState) setPart(part multipartPart) {
+	for i := range state.Parts {
+		if state.Parts[i].Number == part.Number {
+			state.Parts[i] = part
+			return
+		}
+	}
+	state.Parts = append(state.Parts, part)
+}
+
+// partSize returns the part size to upload size bytes with.
+func (s *S3) partSize(size int64) int64 {
+	partSize := int64(s.config.PartSize)
+	if partSize <= 0 {
+		partSize = s3manager.DefaultUploadPartSize
+	}
+	if partSize < s3manager.MinUploadPartSize {
+		partSize = s3manager.MinUploadPartSize
+	}
+	if size/partSize >= s3manager.MaxUploadParts {
+		partSize = size/s3manager.MaxUploadParts + 1
+	}
+	return partSize
+}
+
+// concurrency returns the number of parts to upload in parallel.
+func (s *S3) concurrency() int {
+	if s.config.Concurrency > 0 {
+		return s.config.Concurrency
+	}
+	return s3manager.DefaultUploadConcurrency
+}
+
+// statePath returns the path of the multipart state file of key name.
+func (s *S3) statePath(key string) string {
+	sum := sha256.Sum256([]byte(s.config.Bucket + "/" + s.config.PrefixPath + key))
+	return filepath.Join(s.config.StateDir, hex.EncodeToString(sum[:])+".json")
+}
+
+// uploadResumable uploads source in parts, saving the progress to the state
+// dir so that a later upload of the same key continues where this one stopped.
+func (s *S3) uploadResumable(key string, input *s3manager.UploadInput, source io.ReadSeeker, size int64) error {
+	client := s3.New(s.session)
+	partSize := s.partSize(size)
+	path := s.statePath(key)
+
+	state, err := loadMultipartState(path)
+	if err != nil {
+		log.Printf("upload %q: ignoring multipart state: %v", key, err)
+	}
+	if state != nil && (state.Bucket != aws.StringValue(input.Bucket) || state.Key != aws.StringValue(input.Key) ||
+		state.Size != size || state.PartSize != partSize) {
+		state = nil
+	}
+	if state != nil {
+		if err := s.verifyParts(client, state); isNoSuchUpload(err) {
+			state = nil
+		} else if err != nil {
+			return s3Error("upload", key, err)
+		}
+	}
+
+	if state == nil {
+		output, err := client.CreateMultipartUpload(createMultipartUploadInput(input))
+		if err != nil {
+			return s3Error("upload", key, err)
+		}
+		state = &multipartState{
+			Bucket:   aws.StringValue(input.Bucket),
+			Key:      aws.StringValue(input.Key),
+			UploadID: aws.StringValue(output.UploadId),
+			Size:     size,
+			PartSize: partSize,
+		}
+		if err := saveMultipartState(path, state); err != nil {
+			return err
+		}
+	} else {
+		log.Printf("upload %q: resuming multipart upload with %d parts done", key, len(state.Parts))
+	}
+
+	if err := s.uploadParts(client, state, path, source); err != nil {
+		return s3Error("upload", key, err)
+	}
+
+	sort.Slice(state.Parts, func(i, j int) bool {
+		return state.Parts[i].Number < state.Parts[j].Number
+	})
+	completed := &s3.CompletedMultipartUpload{}
+	for _, part := range state.Parts {
+		completed.Parts = append(completed.Parts, &s3.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int64(part.Number),
+		})
+	}
+	_, err = client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          input.Bucket,
+		Key:             input.Key,
+		UploadId:        aws.String(state.UploadID),
+		MultipartUpload: completed,
+	})
+	if err != nil {
+		if isNoSuchUpload(err) {
+			os.Remove(path)
+		}
+		return s3Error("upload", key, err)
+	}
+	return os.Remove(path)
+}
+
+// verifyParts drops the parts of state that S3 does not have anymore.
+func (s *S3) verifyParts(client *s3.S3, state *multipartState) error {
+	etags := make(map[int64]string)
+	input := &s3.ListPartsInput{
+		Bucket:   aws.String(state.Bucket),
+		Key:      aws.String(state.Key),
+		UploadId: aws.String(state.UploadID),
+	}
+	err := client.ListPartsPages(input, func(page *s3.ListPartsOutput, last bool) bool {
+		for _, part := range page.Parts {
+			etags[aws.Int64Value(part.PartNumber)] = aws.StringValue(part.ETag)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	var parts []multipartPart
+	for _, part := range state.Parts {
+		if etags[part.Number] == part.ETag {
+			parts = append(parts, part)
+		}
+	}
+	state.Parts = parts
+	return nil
+}
+
+// uploadParts reads source one part at a time and uploads the parts that are
+// not done yet, saving state after each one.
+func (s *S3) uploadParts(client *s3.S3, state *multipartState, path string, source io.Reader) error {
+	done := make(map[int64]string)
+	for _, part := range state.Parts {
+		done[part.Number] = part.MD5
+	}
+
+	type job struct {
+		number int64
+		body   []byte
+		sum    string
+	}
+	jobs := make(chan job)
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		lastErr error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return lastErr != nil
+	}
+
+	for i := 0; i < s.concurrency(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				output, err := client.UploadPart(&s3.UploadPartInput{
+					Bucket:     aws.String(state.Bucket),
+					Key:        aws.String(state.Key),
+					UploadId:   aws.String(state.UploadID),
+					PartNumber: aws.Int64(j.number),
+					Body:       bytes.NewReader(j.body),
+				})
+
+				mu.Lock()
+				if err != nil {
+					lastErr = fmt.Errorf("part %d: %v", j.number, err)
+				} else {
+					state.setPart(multipartPart{
+						Number: j.number,
+						ETag:   aws.StringValue(output.ETag),
+						MD5:    j.sum,
+					})
+					if err := saveMultipartState(path, state); err != nil {
+						log.Printf("upload %q: %v", state.Key, err)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var readErr error
+	for number, offset := int64(1), int64(0); offset < state.Size && !failed(); number, offset = number+1, offset+state.PartSize {
+		length := state.PartSize
+		if offset+length > state.Size {
+			length = state.Size - offset
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(source, body); err != nil {
+			readErr = fmt.Errorf("reading part %d: %v", number, err)
+			break
+		}
+
+		sum := md5.Sum(body)
+		if done[number] == hex.EncodeToString(sum[:]) {
+			continue
+		}
+		jobs <- job{number: number, body: body, sum: hex.EncodeToString(sum[:])}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if readErr != nil {
+		return readErr
+	}
+	return lastErr
+}
+
+// createMultipartUploadInput copies the object options of input.
+func createMultipartUploadInput(input *s3manager.UploadInput) *s3.CreateMultipartUploadInput {
+	return &s3.CreateMultipartUploadInput{
+		Bucket:                    input.Bucket,
+		Key:                       input.Key,
+		ACL:                       input.ACL,
+		StorageClass:              input.StorageClass,
+		ServerSideEncryption:      input.ServerSideEncryption,
+		SSEKMSKeyId:               input.SSEKMSKeyId,
+		ObjectLockMode:            input.ObjectLockMode,
+		ObjectLockRetainUntilDate: input.ObjectLockRetainUntilDate,
+		ObjectLockLegalHoldStatus: input.ObjectLockLegalHoldStatus,
+	}
+}
+
+func isNoSuchUpload(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == s3.ErrCodeNoSuchUpload
+}
+
+// loadMultipartState reads the state file at path, or returns nil if there
+// is none.
+func loadMultipartState(path string) (*multipartState, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state multipartState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, fmt.Errorf("decode %q: %v", path, err)
+	}
+	return &state, nil
+}
+
+// saveMultipartState replaces the state file at path.
+func saveMultipartState(path string, state *multipartState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("encode multipart state: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create state dir: %v", err)
+	}
+	tmp := path + tmpInfix + "state"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("write multipart state: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write multipart state: %v", err)
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package storage
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 // WriterWriterAt embeds both io.Writer and io.WriterAt.
 type WriterWriterAt interface {
@@ -14,8 +17,48 @@ type Storage interface {
 	Exists(key string) (bool, error)
 	// Upload writes the source to the storage with key name.
 	Upload(key string, source io.Reader) error
-	// Download reads the item with key name and writes to destination.
+	// Download reads the item with key name and writes to destination. It
+	// returns an error wrapping ErrNotExist if there is no such item.
 	Download(key string, destination WriterWriterAt) error
+	// List returns the key names in the storage that start with prefix.
+	List(prefix string) ([]string, error)
+	// Delete removes the item with key name. Deleting a missing key is not an error.
+	Delete(key string) error
+	// Stat returns information about the item with key name, or an error
+	// wrapping ErrNotExist if there is no such item.
+	Stat(key string) (*ObjectInfo, error)
+}
+
+// Tagger is implemented by storages that can label items with the tags of
+// their backup.
+type Tagger interface {
+	// Tag replaces the tags of the item with key name.
+	Tag(key string, tags []string) error
+}
+
+// AsTagger returns the Tagger of store, looking through middleware that wraps
+// another Storage.
+func AsTagger(store Storage) (Tagger, bool) {
+	for {
+		if tagger, ok := store.(Tagger); ok {
+			return tagger, true
+		}
+		wrapper, ok := store.(interface{ Unwrap() Storage })
+		if !ok {
+			return nil, false
+		}
+		store = wrapper.Unwrap()
+	}
+}
+
+// ObjectInfo describes an item in a storage.
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+	// Checksum is a driver-specific digest of the contents (e.g. an S3 ETag).
+	// It is empty if the driver cannot provide one cheaply.
+	Checksum string
 }
 
 // Storage driver names.
@@ -23,4 +66,8 @@ const (
 	LocalDriver  = "local"
 	RemoteDriver = "remote"
 	S3Driver     = "s3"
+	WebDAVDriver = "webdav"
+	HTTPDriver   = "http"
+	ExecDriver   = "exec"
+	MemoryDriver = "memory"
 )
@@ -0,0 +1,62 @@
+package storage_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/nmcapule/metabox-go/config"
+	"github.com/nmcapule/metabox-go/internal/testenv"
+	"github.com/nmcapule/metabox-go/storage"
+	"github.com/nmcapule/metabox-go/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	t.Run("Memory", func(t *testing.T) {
+		storagetest.Run(t, func(t *testing.T) storage.Storage {
+			return storage.NewMemory()
+		})
+	})
+
+	t.Run("Local", func(t *testing.T) {
+		storagetest.Run(t, func(t *testing.T) storage.Storage {
+			s, err := storage.NewLocal(&config.LocalStorageConfig{Path: tempDir(t)})
+			if err != nil {
+				t.Fatalf("NewLocal() error = %v", err)
+			}
+			return s
+		})
+	})
+
+	t.Run("Exec", func(t *testing.T) {
+		installLocalHelper(t)
+		storagetest.Run(t, func(t *testing.T) storage.Storage {
+			s, err := storage.NewExec(&config.ExecStorageConfig{
+				Name: "local",
+				Args: []string{tempDir(t)},
+			})
+			if err != nil {
+				t.Fatalf("NewExec() error = %v", err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return s
+		})
+	})
+}
+
+// installLocalHelper builds cmd/metabox-storage-local into a directory that
+// is put first in $PATH until t finishes.
+func installLocalHelper(t *testing.T) {
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found:", err)
+	}
+	bin := tempDir(t)
+	cmd := exec.Command(gobin, "build", "-o", filepath.Join(bin, storage.ExecHelperPrefix+"local"),
+		"github.com/nmcapule/metabox-go/cmd/metabox-storage-local")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("building helper: %v\n%s", err, out)
+	}
+	testenv.Setenv(t, "PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
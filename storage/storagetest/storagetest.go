@@ -0,0 +1,231 @@
+// Package storagetest implements a conformance test suite for implementations
+// of storage.Storage.
+//
+// Drivers call Run from their own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) storage.Storage {
+//			return NewMyStore(...)
+//		})
+//	}
+package storagetest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nmcapule/metabox-go/storage"
+)
+
+// LargeObjectSize is the size of the item used by the large object test.
+const LargeObjectSize = 16 << 20
+
+// Run runs the conformance suite against stores created by newStore. Each
+// test gets a fresh store from newStore, which should register any cleanup
+// with t.Cleanup.
+func Run(t *testing.T, newStore func(t *testing.T) storage.Storage) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.Storage)
+	}{
+		{"RoundTrip", testRoundTrip},
+		{"MissingKey", testMissingKey},
+		{"Overwrite", testOverwrite},
+		{"NestedKeys", testNestedKeys},
+		{"List", testList},
+		{"Delete", testDelete},
+		{"Stat", testStat},
+		{"LargeObject", testLargeObject},
+		{"Concurrent", testConcurrent},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+func testRoundTrip(t *testing.T, s storage.Storage) {
+	want := []byte("hello world")
+	mustUpload(t, s, "roundtrip.tar.gz", want)
+
+	if exists, err := s.Exists("roundtrip.tar.gz"); err != nil || !exists {
+		t.Fatalf("Exists() = %v, %v; want true, nil", exists, err)
+	}
+	if got := mustDownload(t, s, "roundtrip.tar.gz"); !bytes.Equal(got, want) {
+		t.Fatalf("Download() = %q; want %q", got, want)
+	}
+}
+
+func testMissingKey(t *testing.T, s storage.Storage) {
+	if exists, err := s.Exists("missing.tar.gz"); err != nil || exists {
+		t.Errorf("Exists() = %v, %v; want false, nil", exists, err)
+	}
+	if err := s.Download("missing.tar.gz", &Buffer{}); !errors.Is(err, storage.ErrNotExist) {
+		t.Errorf("Download() error = %v; want ErrNotExist", err)
+	}
+	if _, err := s.Stat("missing.tar.gz"); !errors.Is(err, storage.ErrNotExist) {
+		t.Errorf("Stat() error = %v; want ErrNotExist", err)
+	}
+	if err := s.Delete("missing.tar.gz"); err != nil {
+		t.Errorf("Delete() = %v; want nil", err)
+	}
+}
+
+func testOverwrite(t *testing.T, s storage.Storage) {
+	mustUpload(t, s, "overwrite.tar.gz", []byte("a rather long first version"))
+	mustUpload(t, s, "overwrite.tar.gz", []byte("short"))
+
+	if got := mustDownload(t, s, "overwrite.tar.gz"); string(got) != "short" {
+		t.Fatalf("Download() = %q; want %q", got, "short")
+	}
+}
+
+func testNestedKeys(t *testing.T, s storage.Storage) {
+	mustUpload(t, s, "a/b/c/nested.tar.gz", []byte("nested"))
+
+	if got := mustDownload(t, s, "a/b/c/nested.tar.gz"); string(got) != "nested" {
+		t.Fatalf("Download() = %q; want %q", got, "nested")
+	}
+}
+
+func testList(t *testing.T, s storage.Storage) {
+	for _, key := range []string{"x/1.tar.gz", "x/2.tar.gz", "y/3.tar.gz", "x.tar.gz"} {
+		mustUpload(t, s, key, []byte(key))
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"", []string{"x.tar.gz", "x/1.tar.gz", "x/2.tar.gz", "y/3.tar.gz"}},
+		{"x", []string{"x.tar.gz", "x/1.tar.gz", "x/2.tar.gz"}},
+		{"x/", []string{"x/1.tar.gz", "x/2.tar.gz"}},
+		{"y/3", []string{"y/3.tar.gz"}},
+		{"z/", nil},
+	}
+	for _, tt := range tests {
+		got, err := s.List(tt.prefix)
+		if err != nil {
+			t.Errorf("List(%q) error = %v", tt.prefix, err)
+			continue
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("List(%q) = %v; want %v", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func testDelete(t *testing.T, s storage.Storage) {
+	mustUpload(t, s, "delete.tar.gz", []byte("delete me"))
+	if err := s.Delete("delete.tar.gz"); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+
+	if exists, err := s.Exists("delete.tar.gz"); err != nil || exists {
+		t.Errorf("Exists() after Delete() = %v, %v; want false, nil", exists, err)
+	}
+	if keys, err := s.List("delete"); err != nil || len(keys) != 0 {
+		t.Errorf("List() after Delete() = %v, %v; want none", keys, err)
+	}
+}
+
+func testStat(t *testing.T, s storage.Storage) {
+	mustUpload(t, s, "stat.tar.gz", []byte("12345"))
+
+	info, err := s.Stat("stat.tar.gz")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Key != "stat.tar.gz" {
+		t.Errorf("Stat().Key = %q; want %q", info.Key, "stat.tar.gz")
+	}
+	if info.Size != 5 {
+		t.Errorf("Stat().Size = %d; want 5", info.Size)
+	}
+	if info.ModTime.IsZero() {
+		t.Errorf("Stat().ModTime is zero")
+	}
+}
+
+func testLargeObject(t *testing.T, s storage.Storage) {
+	want := make([]byte, LargeObjectSize)
+	rand.New(rand.NewSource(1)).Read(want)
+	mustUpload(t, s, "large.tar.gz", want)
+
+	if got := mustDownload(t, s, "large.tar.gz"); !bytes.Equal(got, want) {
+		t.Fatalf("Download() of %d bytes returned %d different bytes", len(want), len(got))
+	}
+}
+
+func testConcurrent(t *testing.T, s storage.Storage) {
+	const workers = 8
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*2)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// Each worker writes its own key and fights over a shared one.
+			key := fmt.Sprintf("concurrent/%d.tar.gz", i)
+			want := bytes.Repeat([]byte{byte(i)}, 1<<16)
+			if err := s.Upload(key, bytes.NewReader(want)); err != nil {
+				errs <- fmt.Errorf("Upload(%q) = %v", key, err)
+				return
+			}
+			if err := s.Upload("concurrent/shared.tar.gz", bytes.NewReader(want)); err != nil {
+				errs <- fmt.Errorf("Upload(shared) = %v", err)
+				return
+			}
+
+			var got Buffer
+			if err := s.Download(key, &got); err != nil {
+				errs <- fmt.Errorf("Download(%q) = %v", key, err)
+				return
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				errs <- fmt.Errorf("Download(%q) returned other contents", key)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// The shared key must hold exactly one of the writes, never a mix.
+	got := mustDownload(t, s, "concurrent/shared.tar.gz")
+	if len(got) != 1<<16 || !bytes.Equal(got, bytes.Repeat(got[:1], len(got))) {
+		t.Errorf("Download(shared) returned torn contents")
+	}
+}
+
+func mustUpload(t *testing.T, s storage.Storage, key string, data []byte) {
+	t.Helper()
+	if err := s.Upload(key, bytes.NewReader(data)); err != nil {
+		t.Fatalf("Upload(%q) error = %v", key, err)
+	}
+}
+
+func mustDownload(t *testing.T, s storage.Storage, key string) []byte {
+	t.Helper()
+	var buf Buffer
+	if err := s.Download(key, &buf); err != nil {
+		t.Fatalf("Download(%q) error = %v", key, err)
+	}
+	return buf.Bytes()
+}
+
+// Buffer is an in-memory storage.WriterWriterAt.
+type Buffer = storage.Buffer
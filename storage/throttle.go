@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// throttleChunk bounds how many bytes are moved between waits, so that
+// transfers stay smooth instead of bursting a whole buffer at once.
+const throttleChunk = 32 << 10
+
+// Clock tells the time and sleeps. It lets the Limiter be driven by a fake
+// clock in tests.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time        { return time.Now() }
+func (systemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// SystemClock is the Clock of the operating system.
+var SystemClock Clock = systemClock{}
+
+// Limiter is a token bucket that limits a transfer rate in bytes per second.
+// It is safe for concurrent use, so one Limiter can be shared by many stores
+// to enforce a combined limit.
+type Limiter struct {
+	clock Clock
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter creates a Limiter allowing rate bytes per second, with bursts of
+// up to one second worth of bytes.
+func NewLimiter(rate int64, clock Clock) *Limiter {
+	return &Limiter{
+		clock:  clock,
+		rate:   float64(rate),
+		burst:  float64(rate),
+		tokens: float64(rate),
+		last:   clock.Now(),
+	}
+}
+
+// Wait blocks until n bytes may be transferred. Bytes taken beyond the
+// available tokens are paid back by sleeping, so callers are served in order.
+func (l *Limiter) Wait(n int) {
+	l.mu.Lock()
+	now := l.clock.Now()
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	tokens := l.tokens
+	l.mu.Unlock()
+
+	if tokens < 0 {
+		l.clock.Sleep(time.Duration(-tokens / l.rate * float64(time.Second)))
+	}
+}
+
+// Throttle wraps a Storage to limit the rate of uploads and downloads.
+type Throttle struct {
+	store   Storage
+	limiter *Limiter
+}
+
+// NewThrottle wraps store so that all transfers wait on limiter.
+func NewThrottle(store Storage, limiter *Limiter) *Throttle {
+	return &Throttle{
+		store:   store,
+		limiter: limiter,
+	}
+}
+
+// Unwrap returns the wrapped Storage.
+func (s *Throttle) Unwrap() Storage {
+	return s.store
+}
+
+func (s *Throttle) Exists(key string) (bool, error) {
+	return s.store.Exists(key)
+}
+
+func (s *Throttle) Upload(key string, source io.Reader) error {
+	return s.store.Upload(key, newThrottledReader(source, s.limiter))
+}
+
+func (s *Throttle) Download(key string, destination WriterWriterAt) error {
+	return s.store.Download(key, newThrottledWriter(destination, s.limiter))
+}
+
+func (s *Throttle) List(prefix string) ([]string, error) {
+	return s.store.List(prefix)
+}
+
+func (s *Throttle) Delete(key string) error {
+	return s.store.Delete(key)
+}
+
+func (s *Throttle) Stat(key string) (*ObjectInfo, error) {
+	return s.store.Stat(key)
+}
+
+// throttledReader waits on a Limiter for every chunk it reads.
+type throttledReader struct {
+	r       io.Reader
+	limiter *Limiter
+}
+
+// throttledReadSeeker is a throttledReader that keeps the source seekable, so
+// drivers can still determine its size and retries can rewind it.
+type throttledReadSeeker struct {
+	*throttledReader
+}
+
+func newThrottledReader(r io.Reader, limiter *Limiter) io.Reader {
+	tr := &throttledReader{r: r, limiter: limiter}
+	if _, ok := r.(io.Seeker); ok {
+		return throttledReadSeeker{tr}
+	}
+	return tr
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := r.r.Read(p)
+	r.limiter.Wait(n)
+	return n, err
+}
+
+func (r throttledReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.r.(io.Seeker).Seek(offset, whence)
+}
+
+// throttledWriter waits on a Limiter for every chunk it writes.
+type throttledWriter struct {
+	w       WriterWriterAt
+	limiter *Limiter
+}
+
+// throttledTruncateWriter is a throttledWriter that keeps the destination
+// truncatable, so retries can still discard partial writes.
+type throttledTruncateWriter struct {
+	*throttledWriter
+}
+
+func newThrottledWriter(w WriterWriterAt, limiter *Limiter) WriterWriterAt {
+	tw := &throttledWriter{w: w, limiter: limiter}
+	if _, ok := w.(truncateSeeker); ok {
+		return throttledTruncateWriter{tw}
+	}
+	return tw
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > throttleChunk {
+			chunk = chunk[:throttleChunk]
+		}
+		w.limiter.Wait(len(chunk))
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *throttledWriter) WriteAt(p []byte, off int64) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > throttleChunk {
+			chunk = chunk[:throttleChunk]
+		}
+		w.limiter.Wait(len(chunk))
+		n, err := w.w.WriteAt(chunk, off)
+		written += n
+		off += int64(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w throttledTruncateWriter) Seek(offset int64, whence int) (int64, error) {
+	return w.w.(truncateSeeker).Seek(offset, whence)
+}
+
+func (w throttledTruncateWriter) Truncate(size int64) error {
+	return w.w.(truncateSeeker).Truncate(size)
+}
@@ -0,0 +1,114 @@
+package storage_test
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nmcapule/metabox-go/storage"
+	"github.com/nmcapule/metabox-go/storage/storagetest"
+)
+
+func TestLimiter(t *testing.T) {
+	clock := newFakeClock()
+	l := storage.NewLimiter(1000, clock)
+
+	tests := []struct {
+		name  string
+		idle  time.Duration
+		n     int
+		sleep time.Duration
+	}{
+		{"Burst", 0, 1000, 0},
+		{"Exhausted", 0, 500, 500 * time.Millisecond},
+		{"PaidBack", 0, 250, 250 * time.Millisecond},
+		{"Refilled", 250 * time.Millisecond, 250, 0},
+		{"BurstCapped", time.Hour, 1500, 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		clock.Advance(tt.idle)
+		before := clock.Now()
+		l.Wait(tt.n)
+		if got := clock.Now().Sub(before); got != tt.sleep {
+			t.Errorf("%s: Wait(%d) slept %v; want %v", tt.name, tt.n, got, tt.sleep)
+		}
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	const rate = 64 << 10
+	want := bytes.Repeat([]byte("metabox!"), 256<<10/8)
+	// The first second worth of bytes is a burst, the rest is paid for.
+	wantElapsed := time.Duration(len(want)-rate) * time.Second / rate
+
+	clock := newFakeClock()
+	s := storage.NewThrottle(storage.NewMemory(), storage.NewLimiter(rate, clock))
+	start := clock.Now()
+	if err := s.Upload("a.tar.gz", bytes.NewReader(want)); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if got := clock.Now().Sub(start); got != wantElapsed {
+		t.Errorf("Upload() took %v; want %v", got, wantElapsed)
+	}
+
+	clock = newFakeClock()
+	s = storage.NewThrottle(s.Unwrap(), storage.NewLimiter(rate, clock))
+	start = clock.Now()
+	var got storagetest.Buffer
+	if err := s.Download("a.tar.gz", &got); err != nil || !bytes.Equal(got.Bytes(), want) {
+		t.Fatalf("Download() = %d bytes, %v; want %d bytes", len(got.Bytes()), err, len(want))
+	}
+	if got := clock.Now().Sub(start); got != wantElapsed {
+		t.Errorf("Download() took %v; want %v", got, wantElapsed)
+	}
+}
+
+func TestThrottleSharedLimiter(t *testing.T) {
+	const rate = 64 << 10
+	data := bytes.Repeat([]byte("metabox!"), 128<<10/8)
+
+	clock := newFakeClock()
+	limiter := storage.NewLimiter(rate, clock)
+	a := storage.NewThrottle(storage.NewMemory(), limiter)
+	b := storage.NewThrottle(storage.NewMemory(), limiter)
+
+	start := clock.Now()
+	for _, s := range []storage.Storage{a, b} {
+		if err := s.Upload("a.tar.gz", bytes.NewReader(data)); err != nil {
+			t.Fatalf("Upload() error = %v", err)
+		}
+	}
+	// Both stores draw from one bucket, so together they take as long as a
+	// single transfer of both.
+	if got, want := clock.Now().Sub(start), time.Duration(2*len(data)-rate)*time.Second/rate; got != want {
+		t.Errorf("Upload() to both stores took %v; want %v", got, want)
+	}
+}
+
+// fakeClock is a Clock whose time only moves when slept on or advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+// Advance moves the clock forward by d.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
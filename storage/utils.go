@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// expandHome replaces a leading "~/" in path with the user's home directory.
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
+
+// readerSize returns the number of bytes left in r, if it can be determined
+// without consuming r.
+func readerSize(r io.Reader) (int64, bool) {
+	switch r := r.(type) {
+	case nil:
+		return 0, true
+	case interface{ Len() int }:
+		return int64(r.Len()), true
+	case io.Seeker:
+		cur, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, false
+		}
+		if _, err := r.Seek(cur, io.SeekStart); err != nil {
+			return 0, false
+		}
+		return end - cur, true
+	}
+	return 0, false
+}
+
+// WriteFileAtomic calls write with a temporary file next to path, syncs it to
+// disk and renames it to path, so that a failed or interrupted write never
+// leaves a partial file behind.
+func WriteFileAtomic(path string, write func(f *os.File) error) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+tmpInfix+"*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %v", err)
+	}
+	// Clean up on failure. Both are no-ops once the rename succeeds.
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := write(tmp); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(os.FileMode(0644)); err != nil {
+		return fmt.Errorf("chmod %q: %v", tmp.Name(), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("sync %q: %v", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close %q: %v", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename %q: %v", tmp.Name(), err)
+	}
+
+	// Sync the directory so the rename itself survives a crash. Not all
+	// platforms support this, so failures are ignored.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
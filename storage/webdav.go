@@ -0,0 +1,332 @@
+package storage
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/nmcapule/metabox-go/config"
+)
+
+// propfindBody requests the properties needed to list and stat items.
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:">
+  <d:prop>
+    <d:resourcetype/>
+    <d:getcontentlength/>
+    <d:getlastmodified/>
+    <d:getetag/>
+  </d:prop>
+</d:propfind>`
+
+// WebDAV implements a storage on a WebDAV server.
+type WebDAV struct {
+	config *config.WebDAVStorageConfig
+	root   *url.URL // URL of the server collection.
+	base   *url.URL // URL of the prefix path, i.e. root of all keys.
+	client *http.Client
+}
+
+func init() {
+	Register(WebDAVDriver, func(cfg *config.BackupConfig) (Storage, error) {
+		return NewWebDAV(&cfg.WebDAV)
+	})
+}
+
+// NewWebDAV creates a WebDAV storage from config.
+func NewWebDAV(config *config.WebDAVStorageConfig) (*WebDAV, error) {
+	root, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parse webdav url: %v", err)
+	}
+	if root.Scheme != "http" && root.Scheme != "https" {
+		return nil, fmt.Errorf("webdav url %q: scheme must be http or https", config.URL)
+	}
+	base := *root
+	base.Path = path.Join("/", root.Path, config.PrefixPath)
+
+	return &WebDAV{
+		config: config,
+		root:   root,
+		base:   &base,
+		client: http.DefaultClient,
+	}, nil
+}
+
+// url returns the URL of key name. Collections get a trailing slash.
+func (s *WebDAV) url(key string, collection bool) string {
+	u := *s.base
+	u.Path = path.Join(u.Path, key)
+	if collection && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u.String()
+}
+
+// do sends a request to the WebDAV server with authentication attached.
+func (s *WebDAV) do(op, key, method, url string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, &Error{Op: op, Key: key, Err: err}
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if size, ok := readerSize(body); ok {
+		req.ContentLength = size
+	}
+
+	switch {
+	case s.config.Token != "":
+		req.Header.Set("Authorization", "Bearer "+s.config.Token)
+	case s.config.Username != "":
+		req.SetBasicAuth(s.config.Username, s.config.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, &Error{Op: op, Key: key, Kind: ErrUnavailable, Err: err}
+	}
+	return resp, nil
+}
+
+func (s *WebDAV) Exists(key string) (bool, error) {
+	resp, err := s.do("exists", key, http.MethodHead, s.url(key, false), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, httpError("exists", key, resp)
+	}
+}
+
+func (s *WebDAV) Upload(key string, source io.Reader) error {
+	if err := s.mkcolAll(path.Dir(key)); err != nil {
+		return err
+	}
+
+	resp, err := s.do("upload", key, http.MethodPut, s.url(key, false), source, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return httpError("upload", key, resp)
+	}
+	return nil
+}
+
+// mkcolAll creates the collection dir along with any missing parents,
+// including the collections of the prefix path.
+func (s *WebDAV) mkcolAll(dir string) error {
+	u := *s.root
+	for _, segment := range strings.Split(path.Join(s.config.PrefixPath, dir), "/") {
+		if segment == "" || segment == "." {
+			continue
+		}
+		u.Path = path.Join(u.Path, segment) + "/"
+
+		resp, err := s.do("mkcol", dir, "MKCOL", u.String(), nil, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		// 405 Method Not Allowed means the collection already exists.
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+			return httpError("mkcol", dir, resp)
+		}
+	}
+	return nil
+}
+
+func (s *WebDAV) Download(key string, destination WriterWriterAt) error {
+	resp, err := s.do("download", key, http.MethodGet, s.url(key, false), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return httpError("download", key, resp)
+	}
+	if _, err := io.Copy(destination, resp.Body); err != nil {
+		return &Error{Op: "download", Key: key, Kind: ErrUnavailable, Err: err}
+	}
+	return nil
+}
+
+func (s *WebDAV) List(prefix string) ([]string, error) {
+	// Only walk the deepest collection that can contain matching keys.
+	dir := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = prefix[:i]
+	}
+
+	var keys []string
+	err := s.walk(dir, func(info *ObjectInfo) {
+		if strings.HasPrefix(info.Key, prefix) {
+			keys = append(keys, info.Key)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// walk calls fn for each non-collection item under the collection dir.
+func (s *WebDAV) walk(dir string, fn func(*ObjectInfo)) error {
+	responses, err := s.propfind("list", dir, true, "1")
+	if err != nil {
+		if errors.Is(err, ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	for _, r := range responses {
+		key, err := s.keyOf(r.Href)
+		if err != nil {
+			return &Error{Op: "list", Key: dir, Err: err}
+		}
+		// Depth 1 includes the collection itself.
+		if strings.Trim(key, "/") == strings.Trim(dir, "/") {
+			continue
+		}
+
+		if r.isCollection() {
+			if err := s.walk(strings.Trim(key, "/"), fn); err != nil {
+				return err
+			}
+			continue
+		}
+		fn(r.info(key))
+	}
+	return nil
+}
+
+func (s *WebDAV) Delete(key string) error {
+	resp, err := s.do("delete", key, http.MethodDelete, s.url(key, false), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return httpError("delete", key, resp)
+	}
+	return nil
+}
+
+func (s *WebDAV) Stat(key string) (*ObjectInfo, error) {
+	responses, err := s.propfind("stat", key, false, "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(responses) == 0 {
+		return nil, &Error{Op: "stat", Key: key, Kind: ErrNotExist, Err: ErrNotExist}
+	}
+	return responses[0].info(key), nil
+}
+
+// propfind requests item properties of key name at the given depth.
+func (s *WebDAV) propfind(op, key string, collection bool, depth string) ([]davResponse, error) {
+	header := http.Header{
+		"Depth":        {depth},
+		"Content-Type": {`application/xml; charset="utf-8"`},
+	}
+	resp, err := s.do(op, key, "PROPFIND", s.url(key, collection), strings.NewReader(propfindBody), header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, httpError(op, key, resp)
+	}
+
+	var ms davMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, &Error{Op: op, Key: key, Err: fmt.Errorf("decode multistatus: %v", err)}
+	}
+	return ms.Responses, nil
+}
+
+// keyOf converts a href from a multistatus response to a key name.
+func (s *WebDAV) keyOf(href string) (string, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", fmt.Errorf("parse href %q: %v", href, err)
+	}
+	root := strings.TrimSuffix(s.base.Path, "/") + "/"
+	if !strings.HasPrefix(u.Path+"/", root) {
+		return "", fmt.Errorf("href %q outside of %q", href, root)
+	}
+	return strings.TrimPrefix(u.Path, root), nil
+}
+
+type davMultistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href     string `xml:"DAV: href"`
+	Propstat []struct {
+		Status string `xml:"DAV: status"`
+		Prop   struct {
+			ResourceType struct {
+				Collection *struct{} `xml:"DAV: collection"`
+			} `xml:"DAV: resourcetype"`
+			ContentLength string `xml:"DAV: getcontentlength"`
+			LastModified  string `xml:"DAV: getlastmodified"`
+			ETag          string `xml:"DAV: getetag"`
+		} `xml:"DAV: prop"`
+	} `xml:"DAV: propstat"`
+}
+
+func (r *davResponse) isCollection() bool {
+	for _, ps := range r.Propstat {
+		if ps.Prop.ResourceType.Collection != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *davResponse) info(key string) *ObjectInfo {
+	info := &ObjectInfo{Key: key}
+	for _, ps := range r.Propstat {
+		// Skip properties the server could not provide, e.g. "404 Not Found".
+		if !strings.Contains(ps.Status, " 200 ") {
+			continue
+		}
+		if size, err := strconv.ParseInt(ps.Prop.ContentLength, 10, 64); err == nil {
+			info.Size = size
+		}
+		if t, err := http.ParseTime(ps.Prop.LastModified); err == nil {
+			info.ModTime = t
+		}
+		if etag := strings.Trim(ps.Prop.ETag, `"`); etag != "" {
+			info.Checksum = etag
+		}
+	}
+	return info
+}
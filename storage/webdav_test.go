@@ -0,0 +1,67 @@
+package storage_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nmcapule/metabox-go/config"
+	"github.com/nmcapule/metabox-go/storage"
+	"github.com/nmcapule/metabox-go/storage/storagetest"
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAV(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		srv := startWebDAVServer(t, "metabox", "secret")
+		s, err := storage.NewWebDAV(&config.WebDAVStorageConfig{
+			URL:        srv.URL + "/dav",
+			PrefixPath: "backups/project",
+			Username:   "metabox",
+			Password:   "secret",
+		})
+		if err != nil {
+			t.Fatalf("NewWebDAV() error = %v", err)
+		}
+		return s
+	})
+}
+
+func TestWebDAVUnauthorized(t *testing.T) {
+	srv := startWebDAVServer(t, "metabox", "secret")
+	s, err := storage.NewWebDAV(&config.WebDAVStorageConfig{
+		URL:      srv.URL + "/dav",
+		Username: "metabox",
+		Password: "wrong",
+	})
+	if err != nil {
+		t.Fatalf("NewWebDAV() error = %v", err)
+	}
+
+	if _, err := s.Exists("a.tar.gz"); !errors.Is(err, storage.ErrPermission) {
+		t.Errorf("Exists() error = %v; want ErrPermission", err)
+	}
+	if _, err := s.List(""); !errors.Is(err, storage.ErrPermission) {
+		t.Errorf("List() error = %v; want ErrPermission", err)
+	}
+}
+
+// startWebDAVServer serves an in-memory WebDAV file system under /dav to
+// clients with the given basic auth credentials.
+func startWebDAVServer(t *testing.T, username, password string) *httptest.Server {
+	dav := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != username || p != password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		dav.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
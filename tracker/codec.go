@@ -21,6 +21,8 @@ func decodeItemsFromFile(path string) ([]*Item, error) {
 
 	reader := csv.NewReader(file)
 	reader.Comma = separator
+	// Allow entries with fewer columns, i.e. written by older versions.
+	reader.FieldsPerRecord = -1
 
 	// Disable parsing headers.
 	header, err := csvutil.Header(&Item{}, "csv")
@@ -28,7 +30,7 @@ func decodeItemsFromFile(path string) ([]*Item, error) {
 		return nil, fmt.Errorf("retrieving header: %v", err)
 	}
 	// Create CSV decoder.
-	decoder, err := csvutil.NewDecoder(reader, header...)
+	decoder, err := csvutil.NewDecoder(&paddedReader{reader, len(header)}, header...)
 	if err == io.EOF {
 		return nil, nil
 	}
@@ -51,10 +53,11 @@ func decodeItemsFromFile(path string) ([]*Item, error) {
 }
 
 func encodeItemsToFile(path string, items []*Item) error {
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		return fmt.Errorf("opening %s: %v", path, err)
 	}
+	defer file.Close()
 
 	// Sort the items first by ascending timestamp.
 	sort.SliceStable(items, func(i, j int) bool {
@@ -78,3 +81,17 @@ func encodeItemsToFile(path string, items []*Item) error {
 
 	return writer.Error()
 }
+
+// paddedReader fills in missing trailing columns of a record with empty values.
+type paddedReader struct {
+	reader  *csv.Reader
+	columns int
+}
+
+func (r *paddedReader) Read() ([]string, error) {
+	record, err := r.reader.Read()
+	for err == nil && len(record) < r.columns {
+		record = append(record, "")
+	}
+	return record, err
+}
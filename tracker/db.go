@@ -58,6 +58,11 @@ func (db *SimpleFileDB) Put(key string, item *Item) error {
 	return nil
 }
 
+// Delete removes the item with key, if any.
+func (db *SimpleFileDB) Delete(key string) {
+	delete(db.table, key)
+}
+
 func (db *SimpleFileDB) Get(key string) (*Item, error) {
 	item, ok := db.table[key]
 	if !ok {
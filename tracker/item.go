@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/csv"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -11,6 +12,9 @@ import (
 
 const emptyTagsMarker = "-"
 
+// recipientsSeparator joins multiple recipients in a single attribute value.
+const recipientsSeparator = ";"
+
 // Tags is a []string wrapper with custom csv encode/decode.
 type Tags []string
 
@@ -74,9 +78,125 @@ func (t *Time) UnmarshalCSV(data []byte) error {
 	return nil
 }
 
+// Meta holds optional key/value attributes of a backup, such as how it was
+// archived. It is encoded as comma-separated `key=value` pairs.
+type Meta map[string]string
+
+func (m Meta) MarshalCSV() ([]byte, error) {
+	// Workaround serialization if no attributes are available.
+	if len(m) == 0 {
+		return []byte(emptyTagsMarker), nil
+	}
+
+	// Sort keys so that the encoding is stable.
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+m[k])
+	}
+
+	var buf bytes.Buffer
+
+	w := csv.NewWriter(&buf)
+	if err := w.Write(pairs); err != nil {
+		return nil, err
+	}
+	w.Flush()
+
+	return []byte(strings.TrimSpace(buf.String())), w.Error()
+}
+
+func (m *Meta) UnmarshalCSV(data []byte) error {
+	*m = nil
+
+	// Entries written before this column existed have no attributes.
+	if len(data) == 0 || string(data) == emptyTagsMarker {
+		return nil
+	}
+
+	r := csv.NewReader(bytes.NewBuffer(data))
+	pairs, err := r.Read()
+	if err != nil {
+		return err
+	}
+
+	*m = make(Meta)
+	for _, pair := range pairs {
+		i := strings.IndexByte(pair, '=')
+		if i < 0 {
+			return fmt.Errorf("malformed attribute %q", pair)
+		}
+		(*m)[pair[:i]] = pair[i+1:]
+	}
+
+	return nil
+}
+
 type Item struct {
 	ID      string `csv:"hash"`
 	Created Time   `csv:"created_time"`
 	Author  string `csv:"author"`
 	Tags    Tags   `csv:"tags"`
+	Meta    Meta   `csv:"meta"`
+}
+
+// Attribute keys of Item.Meta.
+const (
+	// MetaRecipients lists the encryption recipients of the backup.
+	MetaRecipients = "recipients"
+	// MetaHash names the algorithm that computed the backup's hash.
+	MetaHash = "hash"
+	// MetaHashVersion identifies what the backup's hash covers. Entries
+	// without one were hashed from file paths and contents only.
+	MetaHashVersion = "hash_version"
+	// MetaArchive names the container format of the backup's archive.
+	MetaArchive = "archive"
+	// MetaCompress names the codec that compressed the backup's archive.
+	MetaCompress = "compress"
+	// MetaFiles is the number of files in the backup.
+	MetaFiles = "files"
+	// MetaKeyPrefix prefixes the name of a store to record the key of the
+	// backup's archive in that store.
+	MetaKeyPrefix = "key."
+)
+
+// Recipients returns the encryption recipients of the backup, if encrypted.
+func (item *Item) Recipients() []string {
+	if item.Meta[MetaRecipients] == "" {
+		return nil
+	}
+	return strings.Split(item.Meta[MetaRecipients], recipientsSeparator)
+}
+
+// SetRecipients records the encryption recipients of the backup.
+func (item *Item) SetRecipients(recipients []string) {
+	item.SetMeta(MetaRecipients, strings.Join(recipients, recipientsSeparator))
+}
+
+// Key returns the recorded key of the backup's archive in the named store, or
+// an empty string if none was recorded.
+func (item *Item) Key(store string) string {
+	return item.Meta[MetaKeyPrefix+store]
+}
+
+// SetKey records the key of the backup's archive in the named store.
+func (item *Item) SetKey(store, key string) {
+	item.SetMeta(MetaKeyPrefix+store, key)
+}
+
+// SetMeta sets an attribute of the backup. Empty values remove it.
+func (item *Item) SetMeta(key, value string) {
+	if value == "" {
+		delete(item.Meta, key)
+		return
+	}
+	if item.Meta == nil {
+		item.Meta = make(Meta)
+	}
+	item.Meta[key] = value
 }